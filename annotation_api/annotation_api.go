@@ -1,12 +1,14 @@
 package annotation_api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"github.com/kelseyhightower/envconfig"
+	"fmt"
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
 	"sort"
 	"strings"
@@ -14,6 +16,8 @@ import (
 	"time"
 )
 
+var logger = log.Module("annotation_api")
+
 const (
 	httpPrefix    = "/annotations"
 	zookeeperPath = "/proxym/annotation_api"
@@ -75,11 +79,20 @@ type Config struct {
 	ZookeeperServers string `envconfig:"zookeeper_servers"`
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
 type AnnotationApi struct {
-	change   chan int
+	change   chan string
 	config   *Config
+	done     chan struct{} // Closed once the Notifier has stopped
 	registry *annotationsRegistry
-	zkCon    *zk.Conn
+	// State of the session with Zookeeper, updated by watchConnectionEvents.
+	state      zk.State
+	stateMutex *sync.Mutex
+	zkCon      *zk.Conn
 }
 
 // Annotate implements the Annotator interface.
@@ -105,35 +118,103 @@ func (h *AnnotationApi) Annotate(services []*types.Service) error {
 	return nil
 }
 
+// CheckHealth implements the HealthChecker interface. It fails while the connection to Zookeeper is down.
+func (h *AnnotationApi) CheckHealth() error {
+	h.stateMutex.Lock()
+	state := h.state
+	h.stateMutex.Unlock()
+
+	if state != zk.StateHasSession {
+		return fmt.Errorf("Not connected to Zookeeper (state %s)", state)
+	}
+
+	return nil
+}
+
 // Start implements the Notifier interface.
-func (h *AnnotationApi) Start(refresh chan string, quit chan int, wg *sync.WaitGroup) {
+func (h *AnnotationApi) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	defer h.zkCon.Close()
+	defer close(h.done)
+
 	for {
 		select {
-		case <-h.change:
-			log.AppLog.Debug("Triggering Refresh")
-			refresh <- "refresh"
-		case <-quit:
-			h.zkCon.Close()
-			wg.Done()
+		case path := <-h.change:
+			logger.Debug("Triggering Refresh")
+
+			select {
+			case refresh <- types.NewRefreshEvent("annotation_api", path):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// Preload implements the Preloader interface. It reads all annotations from Zookeeper once instead of waiting for the
+// watches to read them.
+func (h *AnnotationApi) Preload() error {
+	children, _, err := h.zkCon.Children(zookeeperPath)
+	if err != nil {
+		return fmt.Errorf("Error reading zNode %s: %s", zookeeperPath, err)
+	}
+
+	for _, child := range children {
+		path := zookeeperPath + "/" + child
+
+		data, _, err := h.zkCon.Get(path)
+		if err != nil {
+			return fmt.Errorf("Error reading zNode %s: %s", path, err)
+		}
+
+		annotation := &Annotation{}
+		err = json.Unmarshal(data, annotation)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling annotation %s: %s", path, err)
+		}
+
+		h.registry.Add(annotation)
+	}
+
+	return nil
+}
+
+// Passes a change to the Notifier. Returns false if the Notifier has stopped.
+func (h *AnnotationApi) notifyChange(path string) bool {
+	select {
+	case h.change <- path:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
 func (h *AnnotationApi) watchNewAnnotations() {
 	for {
 		children, _, ech, err := h.zkCon.ChildrenW(zookeeperPath)
 		if err != nil {
+			select {
+			case <-h.done:
+				return
+			case <-time.After(time.Second):
+				logger.Error("Error watching zNode %s: %s - retrying", zookeeperPath, err)
+				continue
+			}
 		}
 
 		for _, child := range children {
 			if h.registry.Has(child) == false {
-				log.AppLog.Debug("Starting new watch on '%s'", zookeeperPath+"/"+child)
+				logger.Debug("Starting new watch on '%s'", zookeeperPath+"/"+child)
 				go h.watchAnnotation(child)
 			}
 		}
 
-		<-ech
+		select {
+		case <-ech:
+		case <-h.done:
+			return
+		}
 	}
 }
 
@@ -142,37 +223,53 @@ func (h *AnnotationApi) watchAnnotation(id string) {
 
 	for {
 		data, _, ech, err := h.zkCon.GetW(path)
-		if err != nil {
-			log.ErrorLog.Error("Error reading zNode %s: %s - stopping watch", path, err)
+		if err == zk.ErrNoNode {
+			logger.Debug("zNode %s does not exist anymore - stopping watch", path)
 			return
 		}
 
+		if err != nil {
+			// The annotation stays in place until the connection to Zookeeper is back.
+			select {
+			case <-h.done:
+				return
+			case <-time.After(time.Second):
+				logger.Error("Error reading zNode %s: %s - retrying", path, err)
+				continue
+			}
+		}
+
 		annotation := &Annotation{}
 		err = json.Unmarshal(data, annotation)
 		if err != nil {
 			h.registry.Delete(id)
-			log.ErrorLog.Error("Error unmarshalling annotation %s: %s - stopping watch", path, err)
-			h.change <- 1
+			logger.Error("Error unmarshalling annotation %s: %s - stopping watch", path, err)
+			h.notifyChange(path)
 			return
 		}
 
 		h.registry.Add(annotation)
 
-		h.change <- 1
+		if !h.notifyChange(path) {
+			return
+		}
 
-		<-ech
+		select {
+		case <-ech:
+		case <-h.done:
+			return
+		}
 	}
 }
 
-// Handles global events emitted by the connection to Zookeeper
+// Handles global events emitted by the connection to Zookeeper. The client reconnects on its own, so a lost connection
+// is recorded and reported by CheckHealth until the session is back.
 func (h *AnnotationApi) watchConnectionEvents(ev <-chan zk.Event) {
 	p := zookeeperPath + "/"
 
 	for e := range ev {
-		// Ensure that proxym exits in case Zookeeper goes away
-		if e.State == zk.StateDisconnected {
-			h.zkCon.Close()
-			log.ErrorLog.Fatalf("Disconnected from Zookeeper server %s - shutting down", e.Server)
+		if e.Type == zk.EventSession {
+			h.setState(e)
 		}
 
 		// Delete an annotation from the registry on removal
@@ -180,18 +277,46 @@ func (h *AnnotationApi) watchConnectionEvents(ev <-chan zk.Event) {
 			if strings.HasPrefix(e.Path, p) {
 				id := strings.TrimPrefix(e.Path, p)
 				h.registry.Delete(id)
-				h.change <- 1
-				log.AppLog.Debug("Deleted annotation %s", e.Path)
+				h.notifyChange(e.Path)
+				logger.Debug("Deleted annotation %s", e.Path)
 			}
 		}
 	}
 }
 
+// Records the state of the session with Zookeeper and logs when the connection is lost or back.
+func (h *AnnotationApi) setState(e zk.Event) {
+	h.stateMutex.Lock()
+	previous := h.state
+	h.state = e.State
+	h.stateMutex.Unlock()
+
+	switch {
+	case e.State == zk.StateHasSession && previous != zk.StateHasSession:
+		logger.Info("Connected to Zookeeper server %s", e.Server)
+	case e.State == zk.StateDisconnected || e.State == zk.StateExpired:
+		select {
+		case <-h.done:
+			// The connection has been closed during shutdown
+		default:
+			logger.Error("Lost connection to Zookeeper server %s (state %s)", e.Server, e.State)
+		}
+	}
+}
+
 func NewAnnotationApi(config *Config, zkCon *zk.Conn) *AnnotationApi {
-	c := make(chan int)
+	c := make(chan string)
 	r := &annotationsRegistry{annotations: make(map[string]*Annotation), mutex: &sync.Mutex{}}
 
-	h := &AnnotationApi{change: c, config: config, registry: r, zkCon: zkCon}
+	h := &AnnotationApi{
+		change:     c,
+		config:     config,
+		done:       make(chan struct{}),
+		registry:   r,
+		state:      zk.StateDisconnected,
+		stateMutex: &sync.Mutex{},
+		zkCon:      zkCon,
+	}
 
 	return h
 }
@@ -210,7 +335,7 @@ func createPathInZk(p string, zkCon *zk.Conn) error {
 		if err != nil {
 			return err
 		}
-		log.AppLog.Debug("Created path '%s' in Zookeeper", p)
+		logger.Debug("Created path '%s' in Zookeeper", p)
 	}
 
 	return nil
@@ -233,50 +358,64 @@ func compareDomains(a []string, b []string) bool {
 	return true
 }
 
-func init() {
-	var c Config
+func validate(mc module.Config) error {
+	if mc.(*Config).ZookeeperServers == "" {
+		return errors.New("PROXYM_HTTPAPI_ZOOKEEPER_SERVERS not set")
+	}
 
-	envconfig.Process("proxym_httpapi", &c)
+	return nil
+}
 
-	if c.Enabled {
-		log.AppLog.Debug("Starting Annotation Api")
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
 
-		servers := strings.Split(c.ZookeeperServers, ",")
+	servers := strings.Split(c.ZookeeperServers, ",")
 
-		zkCon, ev, err := zk.Connect(zk.FormatServers(servers), time.Second)
-		if err != nil {
-			log.ErrorLog.Fatalf("Unable to connect to Zookeeper server %s: %s - shutting down", c.ZookeeperServers, err)
-		}
+	zkCon, ev, err := zk.Connect(zk.FormatServers(servers), time.Second)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to Zookeeper server %s: %s", c.ZookeeperServers, err)
+	}
 
-		err = createPathInZk("/proxym", zkCon)
-		if err != nil {
-			zkCon.Close()
-			log.ErrorLog.Fatalf("Error creating zNode %s: %s", "/proxym", err)
-			return
-		}
+	err = createPathInZk("/proxym", zkCon)
+	if err != nil {
+		zkCon.Close()
+		return fmt.Errorf("Error creating zNode %s: %s", "/proxym", err)
+	}
 
-		err = createPathInZk(zookeeperPath, zkCon)
-		if err != nil {
-			zkCon.Close()
-			log.ErrorLog.Fatalf("Error creating zNode %s: %s", zookeeperPath, err)
-			return
-		}
+	err = createPathInZk(zookeeperPath, zkCon)
+	if err != nil {
+		zkCon.Close()
+		return fmt.Errorf("Error creating zNode %s: %s", zookeeperPath, err)
+	}
 
-		api := NewAnnotationApi(&c, zkCon)
+	logger.Debug("Starting Annotation Api")
 
-		go api.watchNewAnnotations()
+	api := NewAnnotationApi(c, zkCon)
 
-		go api.watchConnectionEvents(ev)
+	go api.watchNewAnnotations()
 
-		manager.AddAnnotator(api)
+	go api.watchConnectionEvents(ev)
 
-		manager.AddNotifier(api)
+	m.AddAnnotator(api)
 
-		http := NewHttp(zkCon)
+	m.AddNotifier(api)
 
-		manager.RegisterHttpHandleFunc("DELETE", httpPrefix+"/:serviceId", http.deleteAnnotation)
-		manager.RegisterHttpHandleFunc("GET", httpPrefix, http.listAnnotations)
-		manager.RegisterHttpHandleFunc("OPTIONS", httpPrefix+"/:serviceId", http.optionsAnnotation)
-		manager.RegisterHttpHandleFunc("POST", httpPrefix+"/:serviceId", http.createAnnotation)
-	}
+	http := NewHttp(zkCon)
+
+	m.RegisterHttpHandleFunc("DELETE", httpPrefix+"/:serviceId", http.deleteAnnotation)
+	m.RegisterHttpHandleFunc("GET", httpPrefix, http.listAnnotations)
+	m.RegisterHttpHandleFunc("OPTIONS", httpPrefix+"/:serviceId", http.optionsAnnotation)
+	m.RegisterHttpHandleFunc("POST", httpPrefix+"/:serviceId", http.createAnnotation)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "annotation_api",
+		EnvPrefix: "proxym_httpapi",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
@@ -0,0 +1,35 @@
+package annotation_api
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCheckHealthReportsLostConnection(t *testing.T) {
+	h := NewAnnotationApi(&Config{}, nil)
+	require.NotNil(t, h.CheckHealth())
+
+	ev := make(chan zk.Event)
+	done := make(chan struct{})
+
+	go func() {
+		h.watchConnectionEvents(ev)
+		close(done)
+	}()
+
+	ev <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession, Server: "zk1"}
+	ev <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected, Server: "zk1"}
+	ev <- zk.Event{Type: zk.EventSession, State: zk.StateConnecting, Server: "zk2"}
+	close(ev)
+	<-done
+
+	require.EqualError(t, h.CheckHealth(), "Not connected to Zookeeper (state StateConnecting)")
+
+	ev = make(chan zk.Event, 1)
+	ev <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession, Server: "zk2"}
+	close(ev)
+	h.watchConnectionEvents(ev)
+
+	require.Nil(t, h.CheckHealth())
+}
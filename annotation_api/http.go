@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/samuel/go-zookeeper/zk"
-	"github.com/wndhydrnt/proxym/log"
 	"io/ioutil"
 	"net/http"
 )
@@ -34,14 +33,14 @@ func (h *Http) createAnnotation(w http.ResponseWriter, r *http.Request) {
 	annotation := &Annotation{}
 	err = json.Unmarshal(data, annotation)
 	if err != nil {
-		log.ErrorLog.Error("Error reading from Zookeeper: '%s'", err)
+		logger.Error("Error reading from Zookeeper: '%s'", err)
 		http.Error(w, fmt.Sprintf("Error parsing JSON: '%s'", err), http.StatusBadRequest)
 		return
 	}
 
 	present, _, err := h.zkCon.Exists(serviceZkPath)
 	if err != nil {
-		log.ErrorLog.Error("Error reading from Zookeeper: '%s'", err)
+		logger.Error("Error reading from Zookeeper: '%s'", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -49,7 +48,7 @@ func (h *Http) createAnnotation(w http.ResponseWriter, r *http.Request) {
 	if present {
 		zkData, _, err := h.zkCon.Get(serviceZkPath)
 		if err != nil {
-			log.ErrorLog.Error("Error reading from Zookeeper: '%s'", err)
+			logger.Error("Error reading from Zookeeper: '%s'", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -59,14 +58,14 @@ func (h *Http) createAnnotation(w http.ResponseWriter, r *http.Request) {
 		if oldA.Config != annotation.Config || oldA.ApplicationProtocol != annotation.ApplicationProtocol || oldA.ProxyPath != annotation.ProxyPath || !compareDomains(oldA.Domains, annotation.Domains) {
 			newData, err := json.Marshal(annotation)
 			if err != nil {
-				log.ErrorLog.Error("Error marshalling Annotation: '%s'", err)
+				logger.Error("Error marshalling Annotation: '%s'", err)
 				http.Error(w, "", http.StatusInternalServerError)
 				return
 			}
 
 			_, err = h.zkCon.Set(serviceZkPath, newData, int32(-1))
 			if err != nil {
-				log.ErrorLog.Error("Error updating zNode '%s': '%s'", serviceZkPath, err)
+				logger.Error("Error updating zNode '%s': '%s'", serviceZkPath, err)
 				http.Error(w, "", http.StatusInternalServerError)
 				return
 			}
@@ -74,14 +73,14 @@ func (h *Http) createAnnotation(w http.ResponseWriter, r *http.Request) {
 	} else {
 		newData, err := json.Marshal(annotation)
 		if err != nil {
-			log.ErrorLog.Error("Error marshalling Annotation: '%s'", err)
+			logger.Error("Error marshalling Annotation: '%s'", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
 
 		_, err = h.zkCon.Create(serviceZkPath, newData, int32(0), zk.WorldACL(zk.PermAll))
 		if err != nil {
-			log.ErrorLog.Error("Error creating zNode '%s': '%s'", serviceZkPath, err)
+			logger.Error("Error creating zNode '%s': '%s'", serviceZkPath, err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -98,7 +97,7 @@ func (h *Http) deleteAnnotation(w http.ResponseWriter, r *http.Request) {
 
 	err := h.zkCon.Delete(zookeeperPath+"/"+aId, int32(-1))
 	if err != nil {
-		log.ErrorLog.Error("Error deleting annotation ID '%s': '%s'", aId, err)
+		logger.Error("Error deleting annotation ID '%s': '%s'", aId, err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -113,7 +112,7 @@ func (h *Http) listAnnotations(w http.ResponseWriter, r *http.Request) {
 
 	annotationIds, _, err := h.zkCon.Children(zookeeperPath)
 	if err != nil {
-		log.ErrorLog.Error("Error reading annotation IDs: '%s'", err)
+		logger.Error("Error reading annotation IDs: '%s'", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -122,7 +121,7 @@ func (h *Http) listAnnotations(w http.ResponseWriter, r *http.Request) {
 		path := zookeeperPath + "/" + aId
 		annotationData, _, err := h.zkCon.Get(path)
 		if err != nil {
-			log.ErrorLog.Error("Error reading data of annotation ID '%s': '%s'", aId, err)
+			logger.Error("Error reading data of annotation ID '%s': '%s'", aId, err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -130,7 +129,7 @@ func (h *Http) listAnnotations(w http.ResponseWriter, r *http.Request) {
 		annotation := &Annotation{}
 		err = json.Unmarshal(annotationData, annotation)
 		if err != nil {
-			log.ErrorLog.Error("Error unmarshalling data of annotation ID '%s': '%s'", aId, err)
+			logger.Error("Error unmarshalling data of annotation ID '%s': '%s'", aId, err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -145,7 +144,7 @@ func (h *Http) listAnnotations(w http.ResponseWriter, r *http.Request) {
 
 	anntationListData, err := json.Marshal(annotationList)
 	if err != nil {
-		log.ErrorLog.Error("Error marshalling list of annotations: '%s'", err)
+		logger.Error("Error marshalling list of annotations: '%s'", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/wndhydrnt/proxym/manager"
+	haproxy "github.com/wndhydrnt/proxym/proxy"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+)
+
+// Prints the services of all enabled modules as JSON.
+func servicesCommand(configFile string) error {
+	services, err := liveServices(configFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, string(data))
+
+	return nil
+}
+
+// Renders the template of the proxy module with the services of all enabled modules or with the services read from
+// a file written by the command "services".
+func renderCommand(configFile string, args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	servicesFile := flags.String("services", "", "Path to a JSON file written by 'proxym services'. Uses the services of all enabled modules if empty")
+	flags.Parse(args)
+
+	loader, err := newLoader(manager.New(), configFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := loader.Config("proxy")
+	if err != nil {
+		return err
+	}
+
+	if c.(*haproxy.Config).TemplatePath == "" {
+		return errors.New("PROXYM_PROXY_TEMPLATE_PATH not set")
+	}
+
+	var services []*types.Service
+
+	if *servicesFile == "" {
+		services, err = liveServices(configFile)
+	} else {
+		services, err = readServices(*servicesFile)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	config, err := haproxy.NewGenerator(c.(*haproxy.Config)).Render(services)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stdout, config)
+
+	return nil
+}
+
+// Checks the configuration of the Manager and of every enabled module without setting up any module.
+func validateCommand(configFile string) error {
+	loader, err := newLoader(manager.New(), configFile)
+	if err != nil {
+		return err
+	}
+
+	enabled, errs := loader.Validate()
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("Configuration is invalid")
+	}
+
+	fmt.Fprintf(os.Stdout, "Configuration OK. Enabled modules: %v\n", enabled)
+
+	return nil
+}
+
+// Sets up all enabled modules in a new Manager and returns the services they generate.
+func liveServices(configFile string) ([]*types.Service, error) {
+	m := manager.New()
+
+	loader, err := newLoader(m, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loader.SetupEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Services()
+}
+
+func readServices(path string) ([]*types.Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []*types.Service
+
+	err = json.Unmarshal(data, &services)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading services from '%s': %s", path, err)
+	}
+
+	return services, nil
+}
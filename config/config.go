@@ -0,0 +1,205 @@
+// Package config reads settings of the Manager and of modules from a configuration file and from environment
+// variables.
+//
+// The name of a setting is derived the same way as the name of an environment variable: the value of the
+// `envconfig` tag of a struct field or the name of the field in lower case. The environment variable
+// PROXYM_MARATHON_SERVERS corresponds to the setting "servers" of the module "marathon" in the file:
+//
+//	log:
+//	  encoding: json
+//	manager:
+//	  listen_address: ":5678"
+//	modules:
+//	  marathon:
+//	    enabled: true
+//	    servers: "http://marathon1:8080,http://marathon2:8080"
+//
+// Values are applied in the following order: the `default` tag of a field, the configuration file, the environment
+// variable.
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// File holds the settings read from a configuration file.
+type File struct {
+	Log     map[string]interface{}            `yaml:"log" toml:"log"`
+	Manager map[string]interface{}            `yaml:"manager" toml:"manager"`
+	Modules map[string]map[string]interface{} `yaml:"modules" toml:"modules"`
+}
+
+// LogSettings returns the settings of logging.
+func (f *File) LogSettings() map[string]interface{} {
+	if f == nil {
+		return nil
+	}
+
+	return f.Log
+}
+
+// Module returns the settings of a module. Returns nil if the file does not contain settings for the module.
+func (f *File) Module(name string) map[string]interface{} {
+	if f == nil {
+		return nil
+	}
+
+	return f.Modules[name]
+}
+
+// ManagerSettings returns the settings of the Manager.
+func (f *File) ManagerSettings() map[string]interface{} {
+	if f == nil {
+		return nil
+	}
+
+	return f.Manager
+}
+
+// ReadFile parses a configuration file. The format is detected by the extension of the file. Supported formats are
+// YAML (".yml", ".yaml") and TOML (".toml").
+func ReadFile(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, f)
+	case ".toml":
+		err = toml.Unmarshal(data, f)
+	default:
+		return nil, fmt.Errorf("Unsupported format of configuration file '%s'", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing configuration file '%s': %s", path, err)
+	}
+
+	return f, nil
+}
+
+// Apply sets the fields of spec, a pointer to a struct, from their `default` tags, values and environment variables
+// starting with envPrefix. Returns an error if values contains a setting that does not exist in spec.
+func Apply(spec interface{}, values map[string]interface{}, envPrefix string) error {
+	s := reflect.ValueOf(spec).Elem()
+	t := s.Type()
+
+	known := make(map[string]struct{})
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+
+		name := settingName(t.Field(i))
+		known[name] = struct{}{}
+
+		value := t.Field(i).Tag.Get("default")
+
+		if v, ok := values[name]; ok {
+			value = fmt.Sprint(v)
+		}
+
+		key := strings.ToUpper(envPrefix + "_" + name)
+		if v := os.Getenv(key); v != "" {
+			value = v
+		}
+
+		if value == "" {
+			continue
+		}
+
+		err := setValue(f, value)
+		if err != nil {
+			return fmt.Errorf("Invalid value '%s' of setting '%s': %s", value, name, err)
+		}
+	}
+
+	for name := range values {
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("Unknown setting '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// CopyReloadable copies the values of all fields tagged with `reload:"true"` from src to dst. Both have to be
+// pointers to structs of the same type. Returns the names of fields that differ but cannot be reloaded.
+func CopyReloadable(dst, src interface{}) []string {
+	var notReloadable []string
+
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	t := d.Type()
+
+	for i := 0; i < d.NumField(); i++ {
+		if !d.Field(i).CanSet() {
+			continue
+		}
+
+		if reflect.DeepEqual(d.Field(i).Interface(), s.Field(i).Interface()) {
+			continue
+		}
+
+		if t.Field(i).Tag.Get("reload") != "true" {
+			notReloadable = append(notReloadable, settingName(t.Field(i)))
+			continue
+		}
+
+		d.Field(i).Set(s.Field(i))
+	}
+
+	return notReloadable
+}
+
+func settingName(f reflect.StructField) string {
+	name := f.Tag.Get("envconfig")
+	if name == "" {
+		name = f.Name
+	}
+
+	return strings.ToLower(name)
+}
+
+func setValue(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(value, 0, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+
+	return nil
+}
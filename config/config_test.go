@@ -0,0 +1,75 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testConfig struct {
+	Address  string `envconfig:"address" default:"127.0.0.1"`
+	Enabled  bool
+	Interval int `envconfig:"poll_interval" default:"10" reload:"true"`
+	Name     string
+}
+
+func TestApply(t *testing.T) {
+	os.Setenv("PROXYM_UNITTEST_NAME", "from env")
+	defer os.Unsetenv("PROXYM_UNITTEST_NAME")
+
+	c := &testConfig{}
+	values := map[string]interface{}{"enabled": true, "name": "from file", "poll_interval": 30}
+
+	err := Apply(c, values, "proxym_unittest")
+
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1", c.Address)
+	require.True(t, c.Enabled)
+	require.Equal(t, 30, c.Interval)
+	require.Equal(t, "from env", c.Name)
+}
+
+func TestApplyUnknownSetting(t *testing.T) {
+	err := Apply(&testConfig{}, map[string]interface{}{"unknown": 1}, "proxym_unittest")
+
+	require.NotNil(t, err)
+}
+
+func TestApplyInvalidValue(t *testing.T) {
+	err := Apply(&testConfig{}, map[string]interface{}{"poll_interval": "ten"}, "proxym_unittest")
+
+	require.NotNil(t, err)
+}
+
+func TestCopyReloadable(t *testing.T) {
+	dst := &testConfig{Address: "127.0.0.1", Interval: 10, Name: "old"}
+	src := &testConfig{Address: "127.0.0.1", Interval: 20, Name: "new"}
+
+	notReloadable := CopyReloadable(dst, src)
+
+	require.Equal(t, []string{"name"}, notReloadable)
+	require.Equal(t, 20, dst.Interval)
+	require.Equal(t, "old", dst.Name)
+}
+
+func TestReadFile(t *testing.T) {
+	fixtures, _ := filepath.Abs("../tests/fixtures/config")
+
+	for _, name := range []string{"proxym.yml", "proxym.toml"} {
+		f, err := ReadFile(fixtures + "/" + name)
+
+		require.Nil(t, err)
+		require.Equal(t, "marathon=debug", f.LogSettings()["levels"])
+		require.Equal(t, 500, f.ManagerSettings()["refresh_quiet_period"])
+		require.Equal(t, true, f.Module("unittest")["enabled"])
+		require.Equal(t, "from file", f.Module("unittest")["name"])
+		require.Nil(t, f.Module("unknown"))
+	}
+}
+
+func TestReadFileUnsupportedFormat(t *testing.T) {
+	_, err := ReadFile("../tests/fixtures/haproxy/global.cfg")
+
+	require.NotNil(t, err)
+}
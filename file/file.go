@@ -1,16 +1,17 @@
 package file
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/wndhydrnt/proxym/log"
+	"errors"
+	"fmt"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
 	fsnotify "gopkg.in/fsnotify.v1"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sync"
 )
 
 type Config struct {
@@ -18,14 +19,18 @@ type Config struct {
 	Enabled     bool
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
 type Notifier struct {
 	c *Config
 	w *fsnotify.Watcher
 }
 
-func (n *Notifier) Start(refresh chan string, quit chan int, wg *sync.WaitGroup) {
+func (n *Notifier) Start(ctx context.Context, refresh chan types.RefreshEvent) {
 	defer n.w.Close()
-	defer wg.Done()
 
 	n.w.Add(n.c.ConfigsPath)
 
@@ -34,10 +39,14 @@ func (n *Notifier) Start(refresh chan string, quit chan int, wg *sync.WaitGroup)
 		case event := <-n.w.Events:
 			if filepath.Ext(event.Name) == ".json" {
 				if event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Remove == fsnotify.Remove {
-					refresh <- "refresh"
+					select {
+					case refresh <- types.NewRefreshEvent("file", event.String()):
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
-		case <-quit:
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -117,20 +126,34 @@ func readServiceFromConfig(path string) (*types.Service, error) {
 	return service, err
 }
 
-func init() {
-	var c Config
+func validate(mc module.Config) error {
+	if mc.(*Config).ConfigsPath == "" {
+		return errors.New("PROXYM_FILE_CONFIGS_PATH not set")
+	}
 
-	envconfig.Process("proxym_file", &c)
+	return nil
+}
 
-	if c.Enabled {
-		n, err := NewNotifier(&c)
-		if err != nil {
-			log.ErrorLog.Critical("Unable to initialize Notifier in module file: '%s'", err)
-			return
-		}
-		manager.AddNotifier(n)
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
 
-		sg := NewServiceGenerator(&c)
-		manager.AddServiceGenerator(sg)
+	n, err := NewNotifier(c)
+	if err != nil {
+		return fmt.Errorf("Unable to initialize Notifier: %s", err)
 	}
+	m.AddNotifier(n)
+
+	sg := NewServiceGenerator(c)
+	m.AddServiceGenerator(sg)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "file",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
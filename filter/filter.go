@@ -0,0 +1,198 @@
+// Package filter removes services that should not be exposed by a proxy.
+//
+// A rule consists of conditions separated by commas, e.g. "source=Marathon,id=marathon_public_*". A service matches a
+// rule if it matches every condition of the rule. A service is kept if it matches at least one include rule, or no
+// include rule has been configured, and does not match any exclude rule.
+package filter
+
+import (
+	"fmt"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"github.com/wndhydrnt/proxym/types"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type Config struct {
+	Enabled bool
+	// Rules separated by semicolons. A service that matches one of them is removed.
+	Exclude string
+	// Rules separated by semicolons. Only services that match one of them are kept. Every service is kept if empty.
+	Include string
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
+type condition func(s *types.Service) bool
+
+type rule []condition
+
+func (r rule) matches(s *types.Service) bool {
+	for _, c := range r {
+		if !c(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Filter implements the Filter interface.
+type Filter struct {
+	exclude []rule
+	include []rule
+}
+
+// Filter returns all services that match an include rule and no exclude rule.
+func (f *Filter) Filter(services []*types.Service) []*types.Service {
+	var kept []*types.Service
+
+	for _, s := range services {
+		if f.keep(s) {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+func (f *Filter) keep(s *types.Service) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, s) {
+		return false
+	}
+
+	return !matchesAny(f.exclude, s)
+}
+
+func matchesAny(rules []rule, s *types.Service) bool {
+	for _, r := range rules {
+		if r.matches(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewFilter parses the include and exclude rules of c.
+func NewFilter(c *Config) (*Filter, error) {
+	include, err := parseRules(c.Include)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing include rules: %s", err)
+	}
+
+	exclude, err := parseRules(c.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing exclude rules: %s", err)
+	}
+
+	return &Filter{exclude: exclude, include: include}, nil
+}
+
+func parseRules(value string) ([]rule, error) {
+	var rules []rule
+
+	for _, r := range strings.Split(value, ";") {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+
+		var conditions rule
+
+		for _, c := range strings.Split(r, ",") {
+			cond, err := parseCondition(strings.TrimSpace(c))
+			if err != nil {
+				return nil, err
+			}
+
+			conditions = append(conditions, cond)
+		}
+
+		rules = append(rules, conditions)
+	}
+
+	return rules, nil
+}
+
+// Parses a condition of the form "<field>=<pattern>". All patterns are globs, except the pattern of "id_regex".
+func parseCondition(c string) (condition, error) {
+	parts := strings.SplitN(c, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid condition '%s'", c)
+	}
+
+	field, pattern := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	if field == "id_regex" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(s *types.Service) bool { return re.MatchString(s.Id) }, nil
+	}
+
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern '%s'", pattern)
+	}
+
+	match := func(value string) bool {
+		ok, _ := path.Match(pattern, value)
+		return ok
+	}
+
+	switch field {
+	case "application_protocol":
+		return func(s *types.Service) bool { return match(s.ApplicationProtocol) }, nil
+	case "domain":
+		return func(s *types.Service) bool {
+			for _, d := range s.Domains {
+				if match(d) {
+					return true
+				}
+			}
+
+			return false
+		}, nil
+	case "id":
+		return func(s *types.Service) bool { return match(s.Id) }, nil
+	case "source":
+		return func(s *types.Service) bool { return match(s.Source) }, nil
+	case "transport_protocol":
+		return func(s *types.Service) bool { return match(s.TransportProtocol) }, nil
+	}
+
+	return nil, fmt.Errorf("Unknown field '%s'", field)
+}
+
+func validate(mc module.Config) error {
+	_, err := NewFilter(mc.(*Config))
+
+	return err
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	f, err := NewFilter(mc.(*Config))
+	if err != nil {
+		return err
+	}
+
+	m.AddFilter(f)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "filter",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
+}
@@ -0,0 +1,65 @@
+package filter
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"testing"
+)
+
+var services = []*types.Service{
+	&types.Service{Id: "marathon_public_web_8080", Source: "Marathon", ApplicationProtocol: "http", Domains: []string{"web.example.com"}},
+	&types.Service{Id: "marathon_public_db_5432", Source: "Marathon", TransportProtocol: "tcp"},
+	&types.Service{Id: "marathon_internal_8080", Source: "Marathon", ApplicationProtocol: "http", Domains: []string{"internal.example.local"}},
+	&types.Service{Id: "mesos_master", Source: "Mesos Master", ApplicationProtocol: "http"},
+}
+
+func ids(services []*types.Service) []string {
+	var ids []string
+	for _, s := range services {
+		ids = append(ids, s.Id)
+	}
+
+	return ids
+}
+
+func TestFilterKeepsEverythingWithoutRules(t *testing.T) {
+	f, err := NewFilter(&Config{})
+
+	require.Nil(t, err)
+	require.Len(t, f.Filter(services), 4)
+}
+
+func TestFilterInclude(t *testing.T) {
+	f, err := NewFilter(&Config{Include: "source=Marathon,id=marathon_public_*; source=Mesos*"})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"marathon_public_web_8080", "marathon_public_db_5432", "mesos_master"}, ids(f.Filter(services)))
+}
+
+func TestFilterExclude(t *testing.T) {
+	f, err := NewFilter(&Config{Exclude: "domain=*.local;transport_protocol=tcp"})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"marathon_public_web_8080", "mesos_master"}, ids(f.Filter(services)))
+}
+
+func TestFilterIncludeAndExclude(t *testing.T) {
+	f, err := NewFilter(&Config{Include: "application_protocol=http", Exclude: `id_regex=^mesos_`})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"marathon_public_web_8080", "marathon_internal_8080"}, ids(f.Filter(services)))
+}
+
+func TestNewFilterFailsOnInvalidRules(t *testing.T) {
+	_, err := NewFilter(&Config{Include: "unknown=value"})
+	require.NotNil(t, err)
+
+	_, err = NewFilter(&Config{Include: "id"})
+	require.NotNil(t, err)
+
+	_, err = NewFilter(&Config{Exclude: "id_regex=("})
+	require.NotNil(t, err)
+
+	_, err = NewFilter(&Config{Exclude: "id=["})
+	require.NotNil(t, err)
+}
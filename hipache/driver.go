@@ -1,6 +1,7 @@
 package hipache
 
 import (
+	"fmt"
 	"github.com/mediocregopher/radix.v2/redis"
 )
 
@@ -52,3 +53,28 @@ func newRedisDriver(c *config) (*redisDriver, error) {
 	}
 	return &redisDriver{c: r}, nil
 }
+
+// Records the operations that would be executed instead of executing them. Reads are passed to the wrapped driver.
+type dryRunDriver struct {
+	d          driver
+	operations []string
+}
+
+func (r *dryRunDriver) addBackend(key, backend string) error {
+	r.operations = append(r.operations, fmt.Sprintf("RPUSH %s %s", key, backend))
+	return nil
+}
+
+func (r *dryRunDriver) createFrontend(key, identifier string) error {
+	r.operations = append(r.operations, fmt.Sprintf("RPUSH %s %s", key, identifier))
+	return nil
+}
+
+func (r *dryRunDriver) listBackends(key string) (map[string]struct{}, error) {
+	return r.d.listBackends(key)
+}
+
+func (r *dryRunDriver) removeBackend(key, backend string) error {
+	r.operations = append(r.operations, fmt.Sprintf("LREM %s 0 %s", key, backend))
+	return nil
+}
@@ -3,59 +3,179 @@ package hipache
 import (
 	"errors"
 	"fmt"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
+	"strings"
+	"time"
 )
 
 type config struct {
-	Driver       string `default:"redis"`
-	Enabled      bool
+	Driver  string `default:"redis"`
+	Enabled bool
+	// Interval in seconds at which all services are written instead of only the changes to repair manual edits or a
+	// restart of Redis. 0 disables the interval.
+	FullSyncInterval int `envconfig:"full_sync_interval" default:"300" reload:"true"`
+	// Only write to Redis while this instance is the leader.
+	LeaderOnly   bool   `envconfig:"leader_only"`
 	RedisAddress string `envconfig:"redis_address"`
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *config) IsEnabled() bool {
+	return c.Enabled
+}
+
 type hipache struct {
+	c *config
 	d driver
+	// Set if a write failed. The next refresh writes all services because some changes might be missing.
+	failed bool
+	// Time of the last successful write of all services.
+	lastFullSync time.Time
 }
 
-// Generate implements the ConfigGenerator interface.
+// Generate implements the ConfigGenerator interface. It writes all services.
 func (h *hipache) Generate(services []*types.Service) error {
 	for _, service := range services {
-		if service.ApplicationProtocol != "http" {
-			continue
+		err := h.updateService(service)
+		if err != nil {
+			h.failed = true
+			return err
+		}
+	}
+
+	h.failed = false
+	h.lastFullSync = time.Now()
+
+	return nil
+}
+
+// GenerateChanges implements the ChangeSetConfigGenerator interface.
+// Only frontends of services that have been added, changed or removed are touched. All services are written instead
+// on the first call, after a write failed and once PROXYM_HIPACHE_FULL_SYNC_INTERVAL has passed.
+func (h *hipache) GenerateChanges(services []*types.Service, changes *types.ChangeSet) error {
+	if h.fullSyncRequired() {
+		return h.Generate(services)
+	}
+
+	err := h.writeChanges(changes)
+	if err != nil {
+		h.failed = true
+	}
+
+	return err
+}
+
+func (h *hipache) fullSyncRequired() bool {
+	if h.failed || h.lastFullSync.IsZero() {
+		return true
+	}
+
+	interval := time.Duration(h.c.FullSyncInterval) * time.Second
+
+	return interval > 0 && time.Since(h.lastFullSync) >= interval
+}
+
+// Writes the changes to the frontends of added, changed and removed services.
+func (h *hipache) writeChanges(changes *types.ChangeSet) error {
+	for _, service := range changes.Removed {
+		err := h.removeService(service, service.Domains)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, change := range changes.Changed {
+		err := h.removeService(change.Previous, removedDomains(change.Previous, change.Current))
+		if err != nil {
+			return err
+		}
+
+		err = h.updateService(change.Current)
+		if err != nil {
+			return err
 		}
+	}
+
+	for _, service := range changes.Added {
+		err := h.updateService(service)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DryRun implements the DryRunConfigGenerator interface. It returns the Redis commands GenerateChanges would execute,
+// one per line.
+func (h *hipache) DryRun(services []*types.Service, changes *types.ChangeSet) (string, error) {
+	d := &dryRunDriver{d: h.d}
+
+	err := (&hipache{c: h.c, d: d, failed: h.failed, lastFullSync: h.lastFullSync}).GenerateChanges(services, changes)
+	if err != nil {
+		return "", err
+	}
 
-		newB := newBackends(service)
+	return strings.Join(d.operations, "\n"), nil
+}
+
+// Writes the backends of a service to the frontend of each of its domains.
+func (h *hipache) updateService(service *types.Service) error {
+	if service.ApplicationProtocol != "http" {
+		return nil
+	}
 
-		for _, domain := range service.Domains {
-			key := "frontend:" + domain
-			currentB, err := h.d.listBackends(key)
+	newB := newBackends(service)
+
+	for _, domain := range service.Domains {
+		key := "frontend:" + domain
+		currentB, err := h.d.listBackends(key)
+		if err != nil {
+			return err
+		}
+
+		toAdd, toRemove := compare(newB, currentB)
+
+		if len(currentB) == 0 {
+			err := h.d.createFrontend(key, service.Id)
 			if err != nil {
 				return err
 			}
+		}
 
-			toAdd, toRemove := compare(newB, currentB)
-
-			if len(currentB) == 0 {
-				err := h.d.createFrontend(key, service.Id)
-				if err != nil {
-					return err
-				}
+		for _, b := range toRemove {
+			err := h.d.removeBackend(key, b)
+			if err != nil {
+				return err
 			}
+		}
 
-			for _, b := range toRemove {
-				err := h.d.removeBackend(key, b)
-				if err != nil {
-					return err
-				}
+		for _, b := range toAdd {
+			err = h.d.addBackend(key, b)
+			if err != nil {
+				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+// Removes the backends of a service from the frontends of the given domains.
+func (h *hipache) removeService(service *types.Service, domains []string) error {
+	if service.ApplicationProtocol != "http" {
+		return nil
+	}
+
+	for _, domain := range domains {
+		key := "frontend:" + domain
 
-			for _, b := range toAdd {
-				err = h.d.addBackend(key, b)
-				if err != nil {
-					return err
-				}
+		for b, _ := range newBackends(service) {
+			err := h.d.removeBackend(key, b)
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -63,6 +183,31 @@ func (h *hipache) Generate(services []*types.Service) error {
 	return nil
 }
 
+// Returns the domains of the previous version of a service that are not served by the current version.
+func removedDomains(previous, current *types.Service) []string {
+	if current.ApplicationProtocol != "http" {
+		return previous.Domains
+	}
+
+	var removed []string
+
+	for _, pd := range previous.Domains {
+		found := false
+		for _, cd := range current.Domains {
+			if pd == cd {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			removed = append(removed, pd)
+		}
+	}
+
+	return removed
+}
+
 func newBackends(service *types.Service) map[string]struct{} {
 	backends := make(map[string]struct{})
 
@@ -111,21 +256,45 @@ func newHipache(c *config) (*hipache, error) {
 		return nil, err
 	}
 
-	return &hipache{d: d}, nil
+	return &hipache{c: c, d: d}, nil
 }
 
-func init() {
-	var c config
+func validate(mc module.Config) error {
+	c := mc.(*config)
 
-	envconfig.Process("proxym_hipache", &c)
+	if c.RedisAddress == "" {
+		return errors.New("PROXYM_HIPACHE_REDIS_ADDRESS not set")
+	}
 
-	if c.Enabled {
-		h, err := newHipache(&c)
-		if err != nil {
-			log.ErrorLog.Critical("Error initializing Hipache module: %s", err)
-			return
-		}
+	if c.Driver != "redis" {
+		return fmt.Errorf("Unknown storage '%s' in Hipache module", c.Driver)
+	}
 
-		manager.AddConfigGenerator(h)
+	return nil
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*config)
+
+	h, err := newHipache(c)
+	if err != nil {
+		return err
+	}
+
+	if c.LeaderOnly {
+		m.AddLeaderConfigGenerator(h)
+	} else {
+		m.AddConfigGenerator(h)
 	}
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "hipache",
+		NewConfig: func() module.Config { return &config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
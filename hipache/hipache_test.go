@@ -1,12 +1,15 @@
 package hipache
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/wndhydrnt/proxym/types"
 	"testing"
+	"time"
 )
 
 type driverMock struct {
+	addBackendErr    error
 	addedBackends    map[string][]string
 	createdFrontends map[string]string
 	listBackendsFunc func(string) map[string]struct{}
@@ -14,6 +17,10 @@ type driverMock struct {
 }
 
 func (dm *driverMock) addBackend(key, backend string) error {
+	if dm.addBackendErr != nil {
+		return dm.addBackendErr
+	}
+
 	_, ok := dm.addedBackends[key]
 	if ok == false {
 		dm.addedBackends[key] = []string{}
@@ -67,7 +74,7 @@ func TestGenerate(t *testing.T) {
 		},
 	}
 
-	hp := hipache{mock}
+	hp := &hipache{c: &config{}, d: mock}
 
 	hp.Generate(services)
 
@@ -77,3 +84,134 @@ func TestGenerate(t *testing.T) {
 	assert.Len(t, mock.removedBackends, 1)
 	assert.Equal(t, "http://11.11.11.11:8888", mock.removedBackends["frontend:unit.test.devel"][0])
 }
+
+func TestGenerateChanges(t *testing.T) {
+	mock := &driverMock{
+		addedBackends:    make(map[string][]string),
+		createdFrontends: make(map[string]string),
+		listBackendsFunc: func(key string) map[string]struct{} {
+			bs := make(map[string]struct{})
+
+			if key == "frontend:changed.unit.test" {
+				bs["http://10.10.10.10:8888"] = struct{}{}
+			}
+
+			return bs
+		},
+		removedBackends: make(map[string][]string),
+	}
+
+	previous := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"changed.unit.test", "old.unit.test"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.10", Port: 8888}},
+		Id:                  "changed",
+	}
+	current := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"changed.unit.test"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.10", Port: 8888}, types.Host{Ip: "10.10.10.11", Port: 8888}},
+		Id:                  "changed",
+	}
+	removed := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"removed.unit.test"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.12", Port: 8888}},
+		Id:                  "removed",
+	}
+	unchanged := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"unchanged.unit.test"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.13", Port: 8888}},
+		Id:                  "unchanged",
+	}
+
+	changes := types.Diff([]*types.Service{previous, removed, unchanged}, []*types.Service{current, unchanged})
+
+	hp := &hipache{c: &config{}, d: mock, lastFullSync: time.Now()}
+
+	err := hp.GenerateChanges([]*types.Service{current, unchanged}, changes)
+
+	assert.Nil(t, err)
+
+	assert.Len(t, mock.addedBackends, 1)
+	assert.Equal(t, []string{"http://10.10.10.11:8888"}, mock.addedBackends["frontend:changed.unit.test"])
+
+	assert.Len(t, mock.createdFrontends, 0)
+
+	assert.Len(t, mock.removedBackends, 2)
+	assert.Equal(t, []string{"http://10.10.10.10:8888"}, mock.removedBackends["frontend:old.unit.test"])
+	assert.Equal(t, []string{"http://10.10.10.12:8888"}, mock.removedBackends["frontend:removed.unit.test"])
+}
+
+func TestGenerateChangesWritesAllServices(t *testing.T) {
+	mock := &driverMock{
+		addedBackends:    make(map[string][]string),
+		createdFrontends: make(map[string]string),
+		listBackendsFunc: func(key string) map[string]struct{} {
+			return make(map[string]struct{})
+		},
+		removedBackends: make(map[string][]string),
+	}
+
+	service := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"unit.test.devel"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.10", Port: 8888}},
+		Id:                  "unittest",
+	}
+	services := []*types.Service{service}
+	unchanged := types.Diff(services, services)
+
+	hp := &hipache{c: &config{FullSyncInterval: 60}, d: mock}
+
+	// First call after startup
+	assert.Nil(t, hp.GenerateChanges(services, unchanged))
+	assert.Len(t, mock.addedBackends["frontend:unit.test.devel"], 1)
+
+	assert.Nil(t, hp.GenerateChanges(services, unchanged))
+	assert.Len(t, mock.addedBackends["frontend:unit.test.devel"], 1)
+
+	// Interval has passed
+	hp.lastFullSync = time.Now().Add(-2 * time.Minute)
+
+	assert.Nil(t, hp.GenerateChanges(services, unchanged))
+	assert.Len(t, mock.addedBackends["frontend:unit.test.devel"], 2)
+
+	// A write failed
+	mock.addBackendErr = errors.New("connection refused")
+
+	assert.NotNil(t, hp.GenerateChanges(services, types.Diff(nil, services)))
+
+	mock.addBackendErr = nil
+
+	assert.Nil(t, hp.GenerateChanges(services, unchanged))
+	assert.Len(t, mock.addedBackends["frontend:unit.test.devel"], 3)
+}
+
+func TestDryRun(t *testing.T) {
+	mock := &driverMock{
+		addedBackends:    make(map[string][]string),
+		createdFrontends: make(map[string]string),
+		listBackendsFunc: func(key string) map[string]struct{} {
+			return make(map[string]struct{})
+		},
+		removedBackends: make(map[string][]string),
+	}
+
+	service := &types.Service{
+		ApplicationProtocol: "http",
+		Domains:             []string{"unit.test.devel"},
+		Hosts:               []types.Host{types.Host{Ip: "10.10.10.10", Port: 8888}},
+		Id:                  "unittest",
+	}
+
+	hp := &hipache{c: &config{}, d: mock, lastFullSync: time.Now()}
+
+	output, err := hp.DryRun([]*types.Service{service}, types.Diff(nil, []*types.Service{service}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "RPUSH frontend:unit.test.devel unittest\nRPUSH frontend:unit.test.devel http://10.10.10.10:8888", output)
+	assert.Len(t, mock.addedBackends, 0)
+	assert.Len(t, mock.createdFrontends, 0)
+}
@@ -0,0 +1,320 @@
+// Package leader_election elects a leader among several instances of proxym using Zookeeper.
+//
+// Every instance creates an ephemeral, sequential zNode. The instance that owns the zNode with the lowest sequence
+// number is the leader. Every other instance watches the zNode that precedes its own and takes over once that zNode
+// disappears.
+package leader_election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/wndhydrnt/proxym/log"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = log.Module("leader_election")
+
+const nodePrefix = "n_"
+
+type Config struct {
+	Enabled          bool
+	Path             string `default:"/proxym/leader_election"`
+	ZookeeperServers string `envconfig:"zookeeper_servers"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
+// The methods of a connection to Zookeeper used by an Election.
+type zkClient interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	Close()
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	State() zk.State
+}
+
+// Election takes part in the election of a leader.
+type Election struct {
+	config *Config
+	events <-chan zk.Event
+	gauge  prometheus.Gauge
+	leader bool
+	mutex  *sync.Mutex
+	node   string
+	zkCon  zkClient
+}
+
+// CheckHealth implements the HealthChecker interface. It fails while the connection to Zookeeper is down.
+func (e *Election) CheckHealth() error {
+	state := e.zkCon.State()
+	if state != zk.StateHasSession {
+		return fmt.Errorf("Not connected to Zookeeper (state %s)", state)
+	}
+
+	return nil
+}
+
+// IsLeader implements the Elector interface.
+func (e *Election) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.leader
+}
+
+// Start implements the Notifier interface. It takes part in the election until ctx is done and triggers a refresh
+// whenever this instance becomes the leader or loses leadership.
+func (e *Election) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	defer e.zkCon.Close()
+
+	for {
+		err := e.campaign(ctx, refresh)
+		if ctx.Err() != nil {
+			e.resign()
+			return
+		}
+
+		logger.Error("Error taking part in leader election: %s - retrying", err)
+		e.setLeader(ctx, refresh, false)
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			e.resign()
+			return
+		}
+	}
+}
+
+// Creates the zNode of this instance and waits for this instance to become the leader. Returns when ctx is done or an
+// error occurs.
+func (e *Election) campaign(ctx context.Context, refresh chan types.RefreshEvent) error {
+	if e.node == "" {
+		node, err := e.zkCon.Create(e.config.Path+"/"+nodePrefix, nodeData(), zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+		if err != nil {
+			return fmt.Errorf("Error creating zNode in %s: %s", e.config.Path, err)
+		}
+
+		logger.Debug("Created zNode %s for leader election", node)
+		e.node = node
+	}
+
+	for {
+		children, _, err := e.zkCon.Children(e.config.Path)
+		if err != nil {
+			return fmt.Errorf("Error reading children of zNode %s: %s", e.config.Path, err)
+		}
+
+		nodes, pos := position(path.Base(e.node), children)
+		if pos == -1 {
+			// The session expired and Zookeeper removed the zNode.
+			node := e.node
+			e.node = ""
+			return fmt.Errorf("zNode %s does not exist anymore", node)
+		}
+
+		// The leader watches its own zNode, every other instance the zNode that precedes its own.
+		watch := e.node
+		if pos > 0 {
+			watch = e.config.Path + "/" + nodes[pos-1]
+		}
+
+		exists, _, ech, err := e.zkCon.ExistsW(watch)
+		if err != nil {
+			return fmt.Errorf("Error watching zNode %s: %s", watch, err)
+		}
+
+		if !exists {
+			continue
+		}
+
+		e.setLeader(ctx, refresh, pos == 0)
+
+		select {
+		case <-ech:
+		case ev, ok := <-e.events:
+			if !ok {
+				return errors.New("Connection to Zookeeper closed")
+			}
+
+			if ev.State == zk.StateDisconnected || ev.State == zk.StateExpired {
+				return fmt.Errorf("Lost connection to Zookeeper server %s", ev.Server)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Removes the zNode of this instance so that another instance can take over right away.
+func (e *Election) resign() {
+	if e.node == "" {
+		return
+	}
+
+	err := e.zkCon.Delete(e.node, -1)
+	if err != nil {
+		logger.Error("Error deleting zNode %s: %s", e.node, err)
+	}
+}
+
+// Updates the role of this instance and triggers a refresh if the role has changed.
+func (e *Election) setLeader(ctx context.Context, refresh chan types.RefreshEvent, leader bool) {
+	e.mutex.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.mutex.Unlock()
+
+	if leader {
+		e.gauge.Set(1)
+	} else {
+		e.gauge.Set(0)
+	}
+
+	if !changed {
+		return
+	}
+
+	reason := "lost leadership"
+	if leader {
+		reason = "became leader"
+	}
+
+	logger.Info("Leader election: %s", reason)
+
+	select {
+	case refresh <- types.NewRefreshEvent("leader_election", reason):
+	case <-ctx.Done():
+	}
+}
+
+func (e *Election) statusHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(map[string]bool{"leader": e.IsLeader()})
+	if err != nil {
+		logger.Error("Error marshalling status of leader election: '%s'", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Returns the zNodes of the election among children ordered by their sequence number and the position of node among
+// them. The position is -1 if node is not one of the children.
+func position(node string, children []string) ([]string, int) {
+	var nodes []string
+	for _, c := range children {
+		if strings.HasPrefix(c, nodePrefix) {
+			nodes = append(nodes, c)
+		}
+	}
+
+	// Sequence numbers are padded with zeros so sorting the names sorts the sequence numbers.
+	sort.Strings(nodes)
+
+	for i, n := range nodes {
+		if n == node {
+			return nodes, i
+		}
+	}
+
+	return nodes, -1
+}
+
+// Stores the hostname in the zNode to help operators identify the leader.
+func nodeData() []byte {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return []byte{}
+	}
+
+	return []byte(hostname)
+}
+
+// Creates every zNode of p that does not exist.
+func createPath(p string, zkCon *zk.Conn) error {
+	current := ""
+
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		current += "/" + part
+
+		_, err := zkCon.Create(current, []byte{}, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewElection(c *Config, zkCon *zk.Conn, events <-chan zk.Event) *Election {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "leader_election",
+		Name:      "leader",
+		Help:      "1 if this instance is the leader, 0 otherwise",
+	})
+	gauge = prometheus.MustRegisterOrGet(gauge).(prometheus.Gauge)
+
+	return &Election{config: c, events: events, gauge: gauge, mutex: &sync.Mutex{}, zkCon: zkCon}
+}
+
+func validate(mc module.Config) error {
+	if mc.(*Config).ZookeeperServers == "" {
+		return errors.New("PROXYM_LEADER_ELECTION_ZOOKEEPER_SERVERS not set")
+	}
+
+	return nil
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
+
+	servers := strings.Split(c.ZookeeperServers, ",")
+
+	zkCon, ev, err := zk.Connect(zk.FormatServers(servers), time.Second)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to Zookeeper server %s: %s", c.ZookeeperServers, err)
+	}
+
+	err = createPath(c.Path, zkCon)
+	if err != nil {
+		zkCon.Close()
+		return fmt.Errorf("Error creating zNode %s: %s", c.Path, err)
+	}
+
+	e := NewElection(c, zkCon, ev)
+
+	m.SetElector(e)
+	m.AddNotifier(e)
+	m.RegisterHttpHandleFunc("GET", "/leader", e.statusHandler)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "leader_election",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
+}
@@ -0,0 +1,94 @@
+package leader_election
+
+import (
+	"context"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"testing"
+	"time"
+)
+
+type zkClientMock struct {
+	children []string
+	watched  chan string
+}
+
+func (z *zkClientMock) Children(path string) ([]string, *zk.Stat, error) {
+	return z.children, nil, nil
+}
+
+func (z *zkClientMock) Close() {}
+
+func (z *zkClientMock) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	return "", zk.ErrNoNode
+}
+
+func (z *zkClientMock) Delete(path string, version int32) error {
+	return nil
+}
+
+func (z *zkClientMock) ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	z.watched <- path
+
+	return true, nil, make(chan zk.Event), nil
+}
+
+func (z *zkClientMock) State() zk.State {
+	return zk.StateHasSession
+}
+
+func TestPosition(t *testing.T) {
+	children := []string{"n_0000000012", "n_0000000003", "other", "n_0000000007"}
+
+	nodes, pos := position("n_0000000003", children)
+	require.Equal(t, []string{"n_0000000003", "n_0000000007", "n_0000000012"}, nodes)
+	require.Equal(t, 0, pos)
+
+	_, pos = position("n_0000000007", children)
+	require.Equal(t, 1, pos)
+
+	_, pos = position("n_0000000012", children)
+	require.Equal(t, 2, pos)
+
+	_, pos = position("n_0000000001", children)
+	require.Equal(t, -1, pos)
+}
+
+func TestCampaignWatchesPrecedingNode(t *testing.T) {
+	children := []string{"n_0000000012", "other", "n_0000000007", "n_0000000003"}
+
+	tests := []struct {
+		node   string
+		watch  string
+		leader bool
+	}{
+		{node: "n_0000000003", watch: "/election/n_0000000003", leader: true},
+		{node: "n_0000000007", watch: "/election/n_0000000003", leader: false},
+		{node: "n_0000000012", watch: "/election/n_0000000007", leader: false},
+	}
+
+	for _, test := range tests {
+		zkCon := &zkClientMock{children: children, watched: make(chan string, 1)}
+
+		e := NewElection(&Config{Path: "/election"}, nil, make(chan zk.Event))
+		e.zkCon = zkCon
+		e.node = "/election/" + test.node
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+
+		go func() { done <- e.campaign(ctx, make(chan types.RefreshEvent, 1)) }()
+
+		select {
+		case watch := <-zkCon.watched:
+			require.Equal(t, test.watch, watch, test.node)
+		case <-time.After(time.Second):
+			t.Fatalf("%s does not watch a zNode", test.node)
+		}
+
+		cancel()
+		require.Nil(t, <-done)
+		require.Equal(t, test.leader, e.IsLeader(), test.node)
+	}
+}
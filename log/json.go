@@ -0,0 +1,111 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/op/go-logging"
+	"io"
+	"log/syslog"
+	"sync"
+	"time"
+)
+
+// A key and a value attached to a message.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Receives one encoded message at a time.
+type lineWriter interface {
+	writeLine(level logging.Level, line []byte) error
+}
+
+// Writes every message on its own line to an io.Writer.
+type streamWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *streamWriter) writeLine(level logging.Level, line []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.w.Write(append(line, '\n'))
+	return err
+}
+
+// Sends every message to syslog with the priority matching its level.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func (s *syslogWriter) writeLine(level logging.Level, line []byte) error {
+	msg := string(line)
+
+	switch level {
+	case logging.CRITICAL:
+		return s.w.Crit(msg)
+	case logging.ERROR:
+		return s.w.Err(msg)
+	case logging.WARNING:
+		return s.w.Warning(msg)
+	case logging.NOTICE:
+		return s.w.Notice(msg)
+	case logging.INFO:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// jsonBackend implements the Backend interface of go-logging. It encodes every message as a JSON object.
+type jsonBackend struct {
+	w lineWriter
+}
+
+// Log implements the Backend interface of go-logging.
+func (j *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	return j.write(rec.Time, level, rec.Module, rec.Message(), nil)
+}
+
+// Encodes a message in the form {"time":...,"level":...,"module":...,"message":...,<fields>}.
+// The module is left out for AppLog and ErrorLog as they are not bound to a module.
+func (j *jsonBackend) write(t time.Time, level logging.Level, module, msg string, fields []field) error {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	writeField(&buf, "time", t.Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeField(&buf, "level", level.String())
+
+	if module != AppLog.Module && module != ErrorLog.Module {
+		buf.WriteByte(',')
+		writeField(&buf, "module", module)
+	}
+
+	buf.WriteByte(',')
+	writeField(&buf, "message", msg)
+
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeField(&buf, f.key, f.value)
+	}
+
+	buf.WriteByte('}')
+
+	return j.w.writeLine(level, buf.Bytes())
+}
+
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(err.Error())
+	}
+
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+}
@@ -1,9 +1,18 @@
+// Package log configures the loggers of proxym.
+//
+// By default, AppLog writes informational messages to stdout and ErrorLog writes errors to stderr as lines of text.
+// Configure switches to JSON lines or syslog and sets the level of single modules. Components log via a Logger
+// returned by Module, which adds the name of the module and optional fields to every message.
 package log
 
 import (
+	"fmt"
 	"github.com/op/go-logging"
+	"io"
+	"log/syslog"
 	"os"
 	"strings"
+	"sync"
 )
 
 const defaultLogFormat = "%{time:02.01.2006 15:04:05} [%{level}] %{longfunc}: %{message}"
@@ -11,8 +20,58 @@ const defaultLogFormat = "%{time:02.01.2006 15:04:05} [%{level}] %{longfunc}: %{
 var AppLog = logging.MustGetLogger("appLog")
 var ErrorLog = logging.MustGetLogger("errorLog")
 
-var stderr = logging.NewLogBackend(os.Stderr, "", 0)
-var stdout = logging.NewLogBackend(os.Stdout, "", 0)
+var stderr io.Writer = os.Stderr
+var stdout io.Writer = os.Stdout
+
+// Settings of logging. Read from environment variables starting with PROXYM_LOG_ and from the section "log" of the
+// configuration file.
+type Config struct {
+	// Level of AppLog and of every module without its own level.
+	AppLogLevel string `envconfig:"applog_level"`
+	// "text" or "json".
+	Encoding string `default:"text"`
+	// Format of a line of text. See https://godoc.org/github.com/op/go-logging#NewStringFormatter.
+	Format string
+	// Levels of single modules separated by commas, e.g. "marathon=debug,proxy=warning".
+	Levels string
+	// "stdout" or "syslog". "stdout" writes errors to stderr.
+	Output string `default:"stdout"`
+	// Facility of messages sent to syslog.
+	SyslogFacility string `envconfig:"syslog_facility" default:"local0"`
+	// Tag of messages sent to syslog.
+	SyslogTag string `envconfig:"syslog_tag" default:"proxym"`
+	// Write warnings like errors and set the level of ErrorLog to WARNING. Warnings are written like informational
+	// messages otherwise.
+	WarningsToStderr bool `envconfig:"warnings_to_stderr"`
+}
+
+// Backends currently in use. Replaced by Configure.
+type backends struct {
+	app logging.LeveledBackend
+	err logging.LeveledBackend
+	// Set if messages are encoded as JSON.
+	appJSON *jsonBackend
+	errJSON *jsonBackend
+	// Least severe level that goes to the backend of ErrorLog.
+	errLevel logging.Level
+}
+
+// Returns the backend that receives messages of level.
+// Errors and more severe messages go to the backend of ErrorLog, warnings too if Config.WarningsToStderr is set.
+func (b *backends) forLevel(level logging.Level) (logging.LeveledBackend, *jsonBackend) {
+	if level <= b.errLevel {
+		return b.err, b.errJSON
+	}
+
+	return b.app, b.appJSON
+}
+
+var (
+	active        *backends
+	appLogStderr  bool
+	currentConfig = &Config{Encoding: "text", Output: "stdout"}
+	mutex         = &sync.RWMutex{}
+)
 
 func logFormatFromString(ev string) string {
 	if ev == "" {
@@ -43,22 +102,173 @@ func logLevelFromString(ev string) logging.Level {
 	}
 }
 
-func init() {
-	appLogLevel := logLevelFromString(os.Getenv("PROXYM_LOG_APPLOG_LEVEL"))
+// Parses "<module>=<level>" pairs separated by commas.
+func parseLevels(levels string) (map[string]logging.Level, error) {
+	result := make(map[string]logging.Level)
 
-	format := logFormatFromString(os.Getenv("PROXYM_LOG_FORMAT"))
+	for _, pair := range strings.Split(levels, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
 
-	formatter := logging.MustStringFormatter(format)
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid level '%s'", pair)
+		}
 
-	stdoutFormatter := logging.NewBackendFormatter(stdout, formatter)
-	stderrFormatter := logging.NewBackendFormatter(stderr, formatter)
+		level, err := logging.LogLevel(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid level '%s' of module %s", parts[1], parts[0])
+		}
 
-	stderrLeveled := logging.AddModuleLevel(stderrFormatter)
-	stderrLeveled.SetLevel(logging.ERROR, "")
+		result[strings.TrimSpace(parts[0])] = level
+	}
 
-	stdoutLeveled := logging.AddModuleLevel(stdoutFormatter)
-	stdoutLeveled.SetLevel(appLogLevel, "")
+	return result, nil
+}
+
+func syslogFacility(name string) (syslog.Priority, error) {
+	facilities := map[string]syslog.Priority{
+		"daemon": syslog.LOG_DAEMON,
+		"local0": syslog.LOG_LOCAL0,
+		"local1": syslog.LOG_LOCAL1,
+		"local2": syslog.LOG_LOCAL2,
+		"local3": syslog.LOG_LOCAL3,
+		"local4": syslog.LOG_LOCAL4,
+		"local5": syslog.LOG_LOCAL5,
+		"local6": syslog.LOG_LOCAL6,
+		"local7": syslog.LOG_LOCAL7,
+		"user":   syslog.LOG_USER,
+	}
+
+	f, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("Unknown syslog facility '%s'", name)
+	}
+
+	return f, nil
+}
+
+// Creates the backends described by c.
+func newBackends(c *Config, appLogToStderr bool) (*backends, error) {
+	levels, err := parseLevels(c.Levels)
+	if err != nil {
+		return nil, err
+	}
+
+	var app, errors logging.Backend
+	b := &backends{errLevel: logging.ERROR}
+
+	if c.WarningsToStderr {
+		b.errLevel = logging.WARNING
+	}
+
+	switch c.Output {
+	case "stdout":
+		appWriter := stdout
+		if appLogToStderr {
+			appWriter = stderr
+		}
+
+		app = logging.NewLogBackend(appWriter, "", 0)
+		errors = logging.NewLogBackend(stderr, "", 0)
+		b.appJSON = &jsonBackend{w: &streamWriter{w: appWriter}}
+		b.errJSON = &jsonBackend{w: &streamWriter{w: stderr}}
+	case "syslog":
+		facility, err := syslogFacility(c.SyslogFacility)
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := logging.NewSyslogBackendPriority(c.SyslogTag, facility)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to syslog: %s", err)
+		}
+
+		app, errors = s, s
+		b.appJSON = &jsonBackend{w: &syslogWriter{w: s.Writer}}
+		b.errJSON = b.appJSON
+	default:
+		return nil, fmt.Errorf("Unknown output '%s'", c.Output)
+	}
+
+	switch c.Encoding {
+	case "json":
+		b.app = logging.AddModuleLevel(b.appJSON)
+		b.err = logging.AddModuleLevel(b.errJSON)
+	case "text":
+		formatter, err := logging.NewStringFormatter(logFormatFromString(c.Format))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid format '%s': %s", c.Format, err)
+		}
+
+		b.app = logging.AddModuleLevel(logging.NewBackendFormatter(app, formatter))
+		b.err = logging.AddModuleLevel(logging.NewBackendFormatter(errors, formatter))
+		b.appJSON = nil
+		b.errJSON = nil
+	default:
+		return nil, fmt.Errorf("Unknown encoding '%s'", c.Encoding)
+	}
+
+	b.app.SetLevel(logLevelFromString(c.AppLogLevel), "")
+	b.err.SetLevel(b.errLevel, "")
+
+	for module, level := range levels {
+		b.app.SetLevel(level, module)
+		b.err.SetLevel(level, module)
+	}
+
+	return b, nil
+}
+
+// Configure applies c to all loggers. Nothing is changed if c contains an error.
+func Configure(c *Config) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	b, err := newBackends(c, appLogStderr)
+	if err != nil {
+		return err
+	}
+
+	activate(b)
+	currentConfig = c
+
+	return nil
+}
+
+// AppLogToStderr makes AppLog write to stderr instead of stdout, e.g. because stdout is reserved for the output of a
+// command.
+func AppLogToStderr() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	b, err := newBackends(currentConfig, true)
+	if err != nil {
+		return
+	}
+
+	appLogStderr = true
+	activate(b)
+}
+
+func activate(b *backends) {
+	active = b
+
+	AppLog.SetBackend(b.app)
+	ErrorLog.SetBackend(b.err)
+}
+
+func init() {
+	currentConfig.AppLogLevel = os.Getenv("PROXYM_LOG_APPLOG_LEVEL")
+	currentConfig.Format = os.Getenv("PROXYM_LOG_FORMAT")
+
+	b, err := newBackends(currentConfig, false)
+	if err != nil {
+		// An invalid format falls back to the default format.
+		currentConfig.Format = ""
+		b, _ = newBackends(currentConfig, false)
+	}
 
-	AppLog.SetBackend(stdoutLeveled)
-	ErrorLog.SetBackend(stderrLeveled)
+	activate(b)
 }
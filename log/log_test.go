@@ -0,0 +1,163 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// Redirects output to buffers and restores the default configuration after the test.
+func captureOutput(t *testing.T) (*bytes.Buffer, *bytes.Buffer, func()) {
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+
+	stdout = out
+	stderr = errOut
+
+	return out, errOut, func() {
+		stdout = os.Stdout
+		stderr = os.Stderr
+
+		require.Nil(t, Configure(&Config{Encoding: "text", Output: "stdout"}))
+	}
+}
+
+func TestConfigureJSON(t *testing.T) {
+	out, errOut, restore := captureOutput(t)
+	defer restore()
+
+	err := Configure(&Config{Encoding: "json", Levels: "marathon=debug", Output: "stdout"})
+	require.Nil(t, err)
+
+	Module("marathon").With("refresh_id", 3).Debug("Querying %s", "marathon1")
+	Module("proxy").Debug("Not logged")
+	Module("proxy").With("service_id", "webapp").Error("Failed")
+	AppLog.Info("From AppLog")
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var msg map[string]interface{}
+	require.Nil(t, json.Unmarshal(lines[0], &msg))
+	require.Equal(t, "DEBUG", msg["level"])
+	require.Equal(t, "marathon", msg["module"])
+	require.Equal(t, "Querying marathon1", msg["message"])
+	require.Equal(t, float64(3), msg["refresh_id"])
+	require.NotEmpty(t, msg["time"])
+
+	msg = nil
+	require.Nil(t, json.Unmarshal(lines[1], &msg))
+	require.Equal(t, "From AppLog", msg["message"])
+	_, ok := msg["module"]
+	require.False(t, ok)
+
+	msg = nil
+	require.Nil(t, json.Unmarshal(bytes.TrimSpace(errOut.Bytes()), &msg))
+	require.Equal(t, "ERROR", msg["level"])
+	require.Equal(t, "proxy", msg["module"])
+	require.Equal(t, "webapp", msg["service_id"])
+}
+
+func TestConfigureText(t *testing.T) {
+	out, errOut, restore := captureOutput(t)
+	defer restore()
+
+	err := Configure(&Config{Encoding: "text", Format: "%{level} %{module}: %{message}", Output: "stdout"})
+	require.Nil(t, err)
+
+	Module("manager").With("refresh_id", 7).Info("Services changed")
+	Module("manager").Debug("Not logged")
+	Module("manager").Warning("Dropping service")
+	Module("manager").Error("Failed")
+	ErrorLog.Warning("From ErrorLog")
+
+	require.Equal(t, "INFO manager: Services changed refresh_id=7\nWARNING manager: Dropping service\n", out.String())
+	require.Equal(t, "ERROR manager: Failed\n", errOut.String())
+}
+
+func TestConfigureWarningsToStderr(t *testing.T) {
+	out, errOut, restore := captureOutput(t)
+	defer restore()
+
+	err := Configure(&Config{
+		Encoding:         "text",
+		Format:           "%{level} %{module}: %{message}",
+		Output:           "stdout",
+		WarningsToStderr: true,
+	})
+	require.Nil(t, err)
+
+	Module("manager").Info("Services changed")
+	Module("manager").Warning("Dropping service")
+	ErrorLog.Warning("From ErrorLog")
+
+	require.Equal(t, "INFO manager: Services changed\n", out.String())
+	require.Equal(t, "WARNING manager: Dropping service\nWARNING errorLog: From ErrorLog\n", errOut.String())
+}
+
+func TestConfigureLevels(t *testing.T) {
+	out, errOut, restore := captureOutput(t)
+	defer restore()
+
+	err := Configure(&Config{
+		AppLogLevel: "debug",
+		Encoding:    "text",
+		Format:      "%{level} %{module}: %{message}",
+		Levels:      "proxy=error",
+		Output:      "stdout",
+	})
+	require.Nil(t, err)
+
+	Module("manager").Debug("Logged")
+	Module("manager").Warning("Logged")
+	Module("proxy").Info("Not logged")
+	Module("proxy").Warning("Not logged")
+	Module("proxy").Error("Logged")
+
+	require.Equal(t, "DEBUG manager: Logged\nWARNING manager: Logged\n", out.String())
+	require.Equal(t, "ERROR proxy: Logged\n", errOut.String())
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	_, _, restore := captureOutput(t)
+	defer restore()
+
+	configs := []*Config{
+		{Encoding: "xml", Output: "stdout"},
+		{Encoding: "text", Output: "file"},
+		{Encoding: "text", Levels: "marathon", Output: "stdout"},
+		{Encoding: "text", Levels: "marathon=verbose", Output: "stdout"},
+		{Encoding: "text", Output: "syslog", SyslogFacility: "kernel"},
+	}
+
+	for _, c := range configs {
+		require.NotNil(t, Configure(c), "%#v", c)
+	}
+}
+
+func TestAppLogToStderr(t *testing.T) {
+	out, errOut, restore := captureOutput(t)
+	defer restore()
+	defer func() { appLogStderr = false }()
+
+	require.Nil(t, Configure(&Config{Encoding: "json", Output: "stdout"}))
+
+	AppLogToStderr()
+	Module("manager").Info("Moved")
+
+	require.Empty(t, out.String())
+	require.Contains(t, errOut.String(), `"message":"Moved"`)
+}
+
+func TestModuleReportsCaller(t *testing.T) {
+	out, _, restore := captureOutput(t)
+	defer restore()
+
+	require.Nil(t, Configure(&Config{Encoding: "text", Format: "%{shortfunc}: %{message}", Output: "stdout"}))
+
+	Module("manager").Info("Called")
+
+	require.Equal(t, "TestModuleReportsCaller: Called\n", out.String())
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"fmt"
+	"github.com/op/go-logging"
+	"os"
+	"time"
+)
+
+// Logger logs messages of a module.
+//
+// Debug, Info, Notice and Warning messages are written like messages of AppLog. Error and Critical messages are written
+// like messages of ErrorLog, Warning messages too if the setting "warnings_to_stderr" is enabled. The level of each
+// module can be changed via the setting "levels".
+type Logger struct {
+	fields []field
+	module string
+}
+
+// Module returns the Logger of a module. The name of the module is added to every message.
+func Module(name string) *Logger {
+	return &Logger{module: name}
+}
+
+// With returns a copy of the Logger that adds key and value to every message.
+// Lines of text end in " key=value". JSON objects contain "key":value.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+
+	return &Logger{fields: append(fields, field{key: key, value: value}), module: l.module}
+}
+
+func (l *Logger) log(level logging.Level, format string, args ...interface{}) {
+	mutex.RLock()
+	leveled, jsonBackend := active.forLevel(level)
+	mutex.RUnlock()
+
+	if !leveled.IsEnabledFor(level, l.module) {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	if jsonBackend != nil {
+		jsonBackend.write(time.Now(), level, l.module, msg, l.fields)
+		return
+	}
+
+	for _, f := range l.fields {
+		msg += fmt.Sprintf(" %s=%v", f.key, f.value)
+	}
+
+	// ExtraCalldepth points %{longfunc} to the caller of the Logger instead of the Logger itself.
+	logger := &logging.Logger{Module: l.module, ExtraCalldepth: 2}
+	logger.SetBackend(leveled)
+
+	switch level {
+	case logging.CRITICAL:
+		logger.Critical("%s", msg)
+	case logging.ERROR:
+		logger.Error("%s", msg)
+	case logging.WARNING:
+		logger.Warning("%s", msg)
+	case logging.NOTICE:
+		logger.Notice("%s", msg)
+	case logging.INFO:
+		logger.Info("%s", msg)
+	default:
+		logger.Debug("%s", msg)
+	}
+}
+
+func (l *Logger) Critical(format string, args ...interface{}) {
+	l.log(logging.CRITICAL, format, args...)
+}
+
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.log(logging.DEBUG, format, args...)
+}
+
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.log(logging.ERROR, format, args...)
+}
+
+// Fatalf logs a critical message and exits the process.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(logging.CRITICAL, format, args...)
+	os.Exit(1)
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.log(logging.INFO, format, args...)
+}
+
+func (l *Logger) Notice(format string, args ...interface{}) {
+	l.log(logging.NOTICE, format, args...)
+}
+
+func (l *Logger) Warning(format string, args ...interface{}) {
+	l.log(logging.WARNING, format, args...)
+}
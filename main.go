@@ -1,30 +1,138 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	_ "github.com/wndhydrnt/proxym/annotation_api"
 	_ "github.com/wndhydrnt/proxym/file"
+	_ "github.com/wndhydrnt/proxym/filter"
 	_ "github.com/wndhydrnt/proxym/hipache"
+	_ "github.com/wndhydrnt/proxym/leader_election"
 	proxymLog "github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
 	_ "github.com/wndhydrnt/proxym/marathon"
 	_ "github.com/wndhydrnt/proxym/mesos_master"
+	"github.com/wndhydrnt/proxym/module"
+	_ "github.com/wndhydrnt/proxym/plugin"
 	_ "github.com/wndhydrnt/proxym/proxy"
 	_ "github.com/wndhydrnt/proxym/signal"
 	_ "github.com/wndhydrnt/proxym/stdout"
+	_ "github.com/wndhydrnt/proxym/validator"
+	_ "github.com/wndhydrnt/proxym/webhook"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
+const usage = `Usage: proxym [-config <file>] [command]
+
+Commands:
+  services    Print the services of all enabled modules as JSON and exit
+  render      Render the template of the proxy module to stdout and exit
+  validate    Check the configuration of the manager and of every enabled module and exit
+
+Runs until it receives SIGINT or SIGTERM if no command is given.
+
+Flags:
+`
+
 func main() {
+	configFile := flag.String("config", os.Getenv("PROXYM_CONFIG_FILE"), "Path to a configuration file in YAML or TOML format")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		run(*configFile)
+		return
+	}
+
+	// Keep stdout free for the output of the command.
+	proxymLog.AppLogToStderr()
+
+	var err error
+
+	switch flag.Arg(0) {
+	case "render":
+		err = renderCommand(*configFile, flag.Args()[1:])
+	case "services":
+		err = servicesCommand(*configFile)
+	case "validate":
+		err = validateCommand(*configFile)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(configFile string) {
 	proxymLog.AppLog.Info("Starting...")
 
+	err := setup(configFile)
+	if err != nil {
+		proxymLog.ErrorLog.Critical("%s", err)
+		os.Exit(1)
+	}
+
 	go manager.Run()
 
 	sc := make(chan os.Signal, 1)
 
-	signal.Notify(sc, os.Interrupt, os.Kill)
+	signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
 
 	<-sc
-	manager.Quit()
 	proxymLog.AppLog.Info("Shutting down...")
+
+	err = manager.Quit()
+	if err != nil {
+		proxymLog.ErrorLog.Critical("%s", err)
+		os.Exit(1)
+	}
+}
+
+func setup(configFile string) error {
+	loader, err := newLoader(manager.DefaultManager, configFile)
+	if err != nil {
+		return err
+	}
+
+	if configFile != "" {
+		manager.AddNotifier(loader)
+	}
+
+	return loader.SetupEnabled()
+}
+
+// Creates a Loader, applies the settings of logging and, if a configuration file has been set, the settings of the
+// Manager.
+func newLoader(m *manager.Manager, configFile string) (*module.Loader, error) {
+	loader := module.NewLoader(m, configFile)
+
+	err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = loader.ConfigureLogging()
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile == "" {
+		return loader, nil
+	}
+
+	err = loader.ConfigureManager()
+	if err != nil {
+		return nil, err
+	}
+
+	return loader, nil
 }
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"github.com/wndhydrnt/proxym/types"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	services  []*types.Service
+	updatedAt time.Time
+}
+
+// Stores the last successful result of every ServiceGenerator.
+type serviceCache struct {
+	entries map[int]*cacheEntry
+	mutex   *sync.Mutex
+}
+
+// Returns a copy of the services stored for a ServiceGenerator and the time they were stored.
+func (sc *serviceCache) get(id int) ([]*types.Service, time.Time, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	entry, ok := sc.entries[id]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	return copyServices(entry.services), entry.updatedAt, true
+}
+
+// Stores a copy of services. Annotators modify services in place, so a copy is needed to keep the cached result
+// unaltered.
+func (sc *serviceCache) set(id int, services []*types.Service, updatedAt time.Time) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.entries[id] = &cacheEntry{services: copyServices(services), updatedAt: updatedAt}
+}
+
+func copyServices(services []*types.Service) []*types.Service {
+	copies := make([]*types.Service, len(services))
+
+	for i, s := range services {
+		copies[i] = s.Copy()
+	}
+
+	return copies
+}
+
+func newServiceCache() *serviceCache {
+	return &serviceCache{entries: make(map[int]*cacheEntry), mutex: &sync.Mutex{}}
+}
@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A HealthCheck is the result of a single check executed by GET /ready.
+type HealthCheck struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+	Name    string `json:"name"`
+}
+
+type healthStatus struct {
+	Checks []*HealthCheck `json:"checks"`
+	Status string         `json:"status"`
+}
+
+// Tracks the state that the readiness of the Manager depends on.
+type healthState struct {
+	lastSuccess time.Time
+	// Copied from the settings of the Manager because GET /ready must not read settings that can be reloaded.
+	maxRefreshAge    time.Duration
+	mutex            *sync.Mutex
+	stoppedNotifiers map[string]bool
+}
+
+// Copies the settings the checks depend on. Must be called on the goroutine of Run.
+func (hs *healthState) configure(c *Config) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	hs.maxRefreshAge = time.Duration(c.ReadyMaxRefreshAge) * time.Second
+}
+
+func (hs *healthState) refreshSucceeded(t time.Time) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	hs.lastSuccess = t
+}
+
+func (hs *healthState) notifierStopped(name string) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	hs.stoppedNotifiers[name] = true
+}
+
+func newHealthState() *healthState {
+	return &healthState{mutex: &sync.Mutex{}, stoppedNotifiers: make(map[string]bool)}
+}
+
+// Executes every check of the Manager and of all components that implement the HealthChecker interface.
+func (m *Manager) checkReadiness() []*HealthCheck {
+	m.health.mutex.Lock()
+	lastSuccess := m.health.lastSuccess
+	maxAge := m.health.maxRefreshAge
+	var stopped []string
+	for name := range m.health.stoppedNotifiers {
+		stopped = append(stopped, name)
+	}
+	m.health.mutex.Unlock()
+
+	checks := []*HealthCheck{m.checkRefresh(lastSuccess, maxAge)}
+
+	sort.Strings(stopped)
+	notifierCheck := &HealthCheck{Healthy: len(stopped) == 0, Name: "notifiers"}
+	if len(stopped) > 0 {
+		notifierCheck.Message = fmt.Sprintf("Stopped: %v", stopped)
+	}
+	checks = append(checks, notifierCheck)
+
+	for _, c := range m.healthCheckers() {
+		check := &HealthCheck{Healthy: true, Name: componentName(c)}
+
+		err := c.CheckHealth()
+		if err != nil {
+			check.Healthy = false
+			check.Message = err.Error()
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func (m *Manager) checkRefresh(lastSuccess time.Time, maxAge time.Duration) *HealthCheck {
+	check := &HealthCheck{Name: "refresh"}
+
+	if lastSuccess.IsZero() {
+		check.Message = "No refresh has succeeded yet"
+		return check
+	}
+
+	age := time.Since(lastSuccess)
+	check.Message = fmt.Sprintf("Last successful refresh at %s", lastSuccess.Format(time.RFC3339))
+
+	check.Healthy = maxAge <= 0 || age <= maxAge
+
+	return check
+}
+
+// Returns every component added to the Manager that implements the HealthChecker interface. A component is returned
+// once even if it has been added in several roles, e.g. as a Notifier and an Annotator.
+func (m *Manager) healthCheckers() []types.HealthChecker {
+	var components []interface{}
+
+	for _, n := range m.notifiers {
+		components = append(components, n)
+	}
+
+	for _, sg := range m.serviceGenerators {
+		components = append(components, sg.generator)
+	}
+
+	for _, a := range m.annotators {
+		components = append(components, a)
+	}
+
+	for _, cg := range m.configGenerators {
+		components = append(components, cg.generator)
+	}
+
+	var checkers []types.HealthChecker
+
+	for i, c := range components {
+		hc, ok := c.(types.HealthChecker)
+		if !ok || seenBefore(components[:i], c) {
+			continue
+		}
+
+		checkers = append(checkers, hc)
+	}
+
+	return checkers
+}
+
+func seenBefore(components []interface{}, c interface{}) bool {
+	if !reflect.TypeOf(c).Comparable() {
+		return false
+	}
+
+	for _, other := range components {
+		if reflect.TypeOf(other) == reflect.TypeOf(c) && other == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Responds with 200 as long as the process is able to serve HTTP requests.
+func (m *Manager) healthHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []*HealthCheck{&HealthCheck{Healthy: true, Name: "http_server"}}
+
+	writeJSON(w, http.StatusOK, &healthStatus{Checks: checks, Status: "ok"})
+}
+
+// Responds with 200 if all checks succeed and with 503 otherwise.
+func (m *Manager) readyHandler(w http.ResponseWriter, r *http.Request) {
+	status := &healthStatus{Checks: m.checkReadiness(), Status: "ok"}
+	code := http.StatusOK
+
+	for _, c := range status.Checks {
+		if !c.Healthy {
+			status.Status = "failed"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, code, status)
+}
@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type healthCheckerMock struct {
+	configGeneratorMock
+	err error
+}
+
+func (hc *healthCheckerMock) CheckHealth() error {
+	return hc.err
+}
+
+func requestReadiness(m *Manager) (int, *healthStatus) {
+	req, _ := http.NewRequest("GET", "/ready", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	status := &healthStatus{}
+	json.Unmarshal(w.Body.Bytes(), status)
+
+	return w.Code, status
+}
+
+func TestReadyFailsBeforeFirstSuccessfulRefresh(t *testing.T) {
+	m := New()
+
+	code, status := requestReadiness(m)
+
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "failed", status.Status)
+	require.Equal(t, "refresh", status.Checks[0].Name)
+	require.False(t, status.Checks[0].Healthy)
+
+	m.executeRefresh(types.NewRefreshEvent("unittest", "first"), nil)
+
+	code, status = requestReadiness(m)
+
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", status.Status)
+}
+
+func TestReadySucceedsInDryRunMode(t *testing.T) {
+	m := New()
+	m.Config.DryRun = true
+
+	m.executeRefresh(types.NewRefreshEvent("unittest", "first"), nil)
+
+	code, _ := requestReadiness(m)
+
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyFailsIfLastSuccessfulRefreshIsTooOld(t *testing.T) {
+	m := New()
+	m.Config.ReadyMaxRefreshAge = 60
+	m.health.configure(m.Config)
+	m.health.refreshSucceeded(time.Now().Add(-2 * time.Minute))
+
+	code, _ := requestReadiness(m)
+
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestReadyFailsIfNotifierStopped(t *testing.T) {
+	m := New()
+	m.health.refreshSucceeded(time.Now())
+	m.health.notifierStopped("marathon.Watcher")
+
+	code, status := requestReadiness(m)
+
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "notifiers", status.Checks[1].Name)
+	require.Contains(t, status.Checks[1].Message, "marathon.Watcher")
+}
+
+func TestReadyIncludesHealthCheckers(t *testing.T) {
+	hc := &healthCheckerMock{err: errors.New("disconnected")}
+
+	m := New()
+	m.health.refreshSucceeded(time.Now())
+	m.AddConfigGenerator(hc)
+	m.AddLeaderConfigGenerator(hc)
+
+	code, status := requestReadiness(m)
+
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Len(t, status.Checks, 3)
+	require.Equal(t, "manager.healthCheckerMock", status.Checks[2].Name)
+	require.Equal(t, "disconnected", status.Checks[2].Message)
+}
+
+func TestHealth(t *testing.T) {
+	m := New()
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+}
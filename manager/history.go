@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A ComponentResult describes the outcome of calling a ServiceGenerator, Annotator or ConfigGenerator during a
+// refresh.
+type ComponentResult struct {
+	Component string `json:"component"`
+	// Time (in seconds) the call took.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+	// One of "service_generator", "filter", "annotator", "validator" or "config_generator".
+	Kind string `json:"kind"`
+	// What a ConfigGenerator would change during a dry run.
+	Output  string `json:"output,omitempty"`
+	Success bool   `json:"success"`
+}
+
+// A RefreshRecord describes a refresh executed by the Manager.
+type RefreshRecord struct {
+	// Events received while waiting for the quiet period to pass.
+	Coalesced []types.RefreshEvent `json:"coalesced,omitempty"`
+	// Components called during the refresh in the order they have been called.
+	Components []*ComponentResult `json:"components,omitempty"`
+	// Set if ConfigGenerators only described what they would change.
+	DryRun bool   `json:"dryRun,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// The event that triggered the refresh.
+	Event    types.RefreshEvent `json:"event"`
+	Finished time.Time          `json:"finished"`
+	// Increases with every refresh. Added as "refresh_id" to the messages logged during the refresh.
+	Id     uint64 `json:"id"`
+	Result string `json:"result"`
+}
+
+// Keeps the most recent refreshes in memory.
+type refreshHistory struct {
+	mutex   *sync.Mutex
+	records []*RefreshRecord
+}
+
+// Adds a record and drops the oldest records if more than size records are stored.
+func (rh *refreshHistory) add(r *RefreshRecord, size int) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	rh.records = append(rh.records, r)
+
+	if size < 0 {
+		size = 0
+	}
+
+	if len(rh.records) > size {
+		rh.records = rh.records[len(rh.records)-size:]
+	}
+}
+
+// Returns all records, the most recent one first.
+func (rh *refreshHistory) list() []*RefreshRecord {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	records := make([]*RefreshRecord, len(rh.records))
+
+	for i, r := range rh.records {
+		records[len(rh.records)-1-i] = r
+	}
+
+	return records
+}
+
+func (rh *refreshHistory) listHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(rh.list())
+	if err != nil {
+		logger.Error("Error marshalling refresh history: '%s'", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func newRefreshHistory() *refreshHistory {
+	return &refreshHistory{mutex: &sync.Mutex{}}
+}
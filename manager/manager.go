@@ -3,30 +3,120 @@
 package manager
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/bmizerany/pat"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/types"
 	"net/http"
+	"os"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
+var logger = log.Module("manager")
+
+type generateResult struct {
+	duration time.Duration
+	services []*types.Service
+	err      error
+}
+
+// Wraps a ConfigGenerator to store its name and whether it is only called on the leader.
+type configGenerator struct {
+	generator  types.ConfigGenerator
+	leaderOnly bool
+	name       string
+	// Set if the ConfigGenerator has not been called during the previous refresh because this instance was not the
+	// leader. Its state has to be synced completely the next time it is called.
+	skipped bool
+}
+
+// Wraps a ServiceGenerator to store its name.
+type serviceGenerator struct {
+	generator types.ContextServiceGenerator
+	name      string
+}
+
+// Settings of the Manager. Fields tagged with `reload:"true"` can be changed while the Manager is running.
 type Config struct {
-	ListenAddress string `envconfig:"listen_address",default:":5678"`
+	// ConfigGenerators only describe what they would change instead of changing it.
+	DryRun bool `envconfig:"dry_run" reload:"true"`
+	// Address the HTTP server listens on.
+	ListenAddress string `envconfig:"listen_address" default:":80"`
+	// What happens if several ServiceGenerators generate a service with the same ID. One of MergeNone,
+	// MergePriority, MergeUnion or MergeError.
+	MergePolicy string `envconfig:"merge_policy" reload:"true"`
+	// Upper limit of time (in milliseconds) a burst of refresh signals can delay a refresh. 0 means no limit.
+	RefreshMaxDelay int `envconfig:"refresh_max_delay" reload:"true"`
+	// Number of refreshes to keep in memory.
+	RefreshHistorySize int `envconfig:"refresh_history_size" default:"100" reload:"true"`
+	// Time (in milliseconds) without a new refresh signal after which a refresh is executed. 0 disables coalescing.
+	RefreshQuietPeriod int `envconfig:"refresh_quiet_period" reload:"true"`
+	// Time (in seconds) after the last successful refresh after which GET /ready fails. 0 disables the check.
+	ReadyMaxRefreshAge int `envconfig:"ready_max_refresh_age" reload:"true"`
+	// Time (in seconds) the last successful result of a ServiceGenerator is used in case the ServiceGenerator fails.
+	// 0 disables the fallback.
+	ServiceCacheMaxAge int `envconfig:"service_cache_max_age" default:"300" reload:"true"`
+	// Time (in seconds) a ServiceGenerator is allowed to take before it is considered failed. 0 means no timeout.
+	ServiceGeneratorTimeout int `envconfig:"service_generator_timeout" default:"30" reload:"true"`
+	// Time (in seconds) to wait for Notifiers, the HTTP server and the current refresh to finish on shutdown.
+	ShutdownTimeout int `envconfig:"shutdown_timeout" default:"30"`
+	// Sources separated by commas, the Source with the highest priority first. Used by MergePolicy.
+	SourcePriority string `envconfig:"source_priority" reload:"true"`
+	// Path of the file that stores the services of the last successful refresh. Empty disables the state file.
+	StateFile string `envconfig:"state_file"`
 }
 
 // Manager orchestrates Notifiers, ServiceGenerators and ConfigGenerators.
 type Manager struct {
 	annotators        []types.Annotator
+	cancel            context.CancelFunc
+	componentDuration *prometheus.HistogramVec
+	components        []*ComponentResult
 	Config            *Config
-	configGenerators  []types.ConfigGenerator
-	httpRouter        *pat.PatternServeMux
-	notifiers         []types.Notifier
-	quit              chan int
-	refresh           chan string
-	refreshCounter    *prometheus.CounterVec
-	serviceGenerators []types.ServiceGenerator
+	configGenerators  []*configGenerator
+	ctx               context.Context
+	// Event that triggered the refresh that is executed right now.
+	currentEvent  types.RefreshEvent
+	done          chan struct{}
+	elector       types.Elector
+	filteredGauge *prometheus.GaugeVec
+	filters       []types.Filter
+	health        *healthState
+	history       *refreshHistory
+	hostsGauge    *prometheus.GaugeVec
+	httpRouter    *pat.PatternServeMux
+	lastChange    prometheus.Gauge
+	lastSuccess   prometheus.Gauge
+	notifiers     []types.Notifier
+	// Services passed to ConfigGenerators by the previous refresh or read from the state file. nil if unknown.
+	previousServices []*types.Service
+	reconfigure      chan func()
+	refresh          chan types.RefreshEvent
+	// ServiceGenerators are cancelled once it is done. Same as ctx except during the final refresh before shutdown.
+	refreshCtx       context.Context
+	refreshCoalesced *prometheus.CounterVec
+	refreshCounter   *prometheus.CounterVec
+	refreshId        uint64
+	// Logs messages of the current refresh.
+	refreshLog        *log.Logger
+	rejectedGauge     *prometheus.GaugeVec
+	serviceCache      *serviceCache
+	serviceGenerators []*serviceGenerator
+	services          *serviceRegistry
+	servicesGauge     *prometheus.GaugeVec
+	sgErrorCounter    *prometheus.CounterVec
+	sgStaleness       *prometheus.GaugeVec
+	snapshot          *state
+	server            *http.Server
+	validators        []types.Validator
+	waiters           *refreshWaiters
 	waitGroup         *sync.WaitGroup
 }
 
@@ -37,9 +127,35 @@ func (m *Manager) AddAnnotator(a types.Annotator) *Manager {
 	return m
 }
 
+// Add a Filter.
+func (m *Manager) AddFilter(f types.Filter) *Manager {
+	m.filters = append(m.filters, f)
+
+	return m
+}
+
+// Add a Validator.
+func (m *Manager) AddValidator(v types.Validator) *Manager {
+	m.validators = append(m.validators, v)
+
+	return m
+}
+
 // Add a ConfigGenerator.
 func (m *Manager) AddConfigGenerator(cg types.ConfigGenerator) *Manager {
-	m.configGenerators = append(m.configGenerators, cg)
+	m.configGenerators = append(m.configGenerators, &configGenerator{generator: cg, name: componentName(cg)})
+
+	return m
+}
+
+// Add a ConfigGenerator that is only called while this instance is the leader. Every instance is the leader if no
+// Elector has been set.
+func (m *Manager) AddLeaderConfigGenerator(cg types.ConfigGenerator) *Manager {
+	m.configGenerators = append(m.configGenerators, &configGenerator{
+		generator:  cg,
+		leaderOnly: true,
+		name:       componentName(cg),
+	})
 
 	return m
 }
@@ -53,14 +169,43 @@ func (m *Manager) AddNotifier(notifier types.Notifier) *Manager {
 
 // Add a ServiceGenerator
 func (m *Manager) AddServiceGenerator(sg types.ServiceGenerator) *Manager {
-	m.serviceGenerators = append(m.serviceGenerators, sg)
+	m.serviceGenerators = append(m.serviceGenerators, &serviceGenerator{
+		generator: types.AdaptServiceGenerator(sg),
+		name:      componentName(sg),
+	})
 
 	return m
 }
 
+// Add a ContextServiceGenerator
+func (m *Manager) AddContextServiceGenerator(csg types.ContextServiceGenerator) *Manager {
+	m.serviceGenerators = append(m.serviceGenerators, &serviceGenerator{
+		generator: csg,
+		name:      componentName(csg),
+	})
+
+	return m
+}
+
+// Set the Elector that decides whether this instance is the leader.
+func (m *Manager) SetElector(e types.Elector) *Manager {
+	m.elector = e
+
+	return m
+}
+
+// IsLeader reports whether this instance is the leader. Returns true if no Elector has been set.
+func (m *Manager) IsLeader() bool {
+	if m.elector == nil {
+		return true
+	}
+
+	return m.elector.IsLeader()
+}
+
 // Register an endpoint with the HTTP server
 func (m *Manager) RegisterHttpHandler(method string, path string, handle http.Handler) *Manager {
-	log.AppLog.Debug("Registering HTTP endpoint on '%s' with method '%s'", path, method)
+	logger.Debug("Registering HTTP endpoint on '%s' with method '%s'", path, method)
 
 	m.httpRouter.Add(method, path, prometheus.InstrumentHandler(path, handle))
 
@@ -73,88 +218,793 @@ func (m *Manager) RegisterHttpHandleFunc(method, path string, handle func(w http
 
 // Starts every notifier and listens for messages that trigger a refresh.
 // When a refresh is triggered it calls all ServiceGenerators and then all ConfigGenerators.
+// Run blocks until Quit is called.
 func (m *Manager) Run() {
-	m.waitGroup = &sync.WaitGroup{}
-	m.waitGroup.Add(len(m.notifiers))
+	defer close(m.done)
 
 	for _, notifier := range m.notifiers {
-		go notifier.Start(m.refresh, m.quit, m.waitGroup)
+		m.waitGroup.Add(1)
+
+		go func(n types.Notifier) {
+			defer m.waitGroup.Done()
+
+			n.Start(m.ctx, m.refresh)
+
+			if m.ctx.Err() == nil {
+				logger.Error("Notifier %s stopped unexpectedly", componentName(n))
+				m.health.notifierStopped(componentName(n))
+				return
+			}
+
+			logger.Debug("Notifier %s stopped", componentName(n))
+		}(notifier)
 	}
 
-	go http.ListenAndServe(m.Config.ListenAddress, m.httpRouter)
+	m.server.Addr = m.Config.ListenAddress
+
+	go func() {
+		err := m.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server stopped: %s", err)
+		}
+	}()
+
+	if m.elector == nil {
+		for _, cg := range m.configGenerators {
+			if cg.leaderOnly {
+				logger.Warning("ConfigGenerator %s should only run on the leader but leader election is disabled", cg.name)
+			}
+		}
+	}
+
+	if m.Config.StateFile != "" {
+		m.loadState()
+	}
 
 	// Refresh right on startup
-	m.process()
+	m.executeRefresh(types.NewRefreshEvent("manager", "startup"), nil)
 
-	for _ = range m.refresh {
-		log.AppLog.Debug("Refresh received")
-		err := m.process()
-		if err != nil {
-			log.ErrorLog.Error("%s", err)
-			m.refreshCounter.WithLabelValues("error").Inc()
-		} else {
-			m.refreshCounter.WithLabelValues("success").Inc()
+	for {
+		select {
+		case event := <-m.refresh:
+			logger.Info("Refresh triggered by %s: %s", event.Notifier, event.Reason)
+
+			coalesced := m.coalesce()
+			if len(coalesced) > 0 {
+				logger.Debug("Coalesced %d refresh signals", len(coalesced))
+
+				for _, c := range coalesced {
+					m.refreshCoalesced.WithLabelValues(c.Notifier).Inc()
+				}
+			}
+
+			m.executeRefresh(event, coalesced)
+		case f := <-m.reconfigure:
+			f()
+		case <-m.ctx.Done():
+			m.flush()
+			return
+		}
+	}
+}
+
+// Reads the state file written by a previous run. Its services are used until ServiceGenerators respond.
+func (m *Manager) loadState() {
+	st, err := readState(m.Config.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Error reading state file '%s': %s", m.Config.StateFile, err)
 		}
+
+		return
+	}
+
+	logger.Info("Read %d services from state file '%s' written at %s", len(st.Services), m.Config.StateFile, st.Written.Format(time.RFC3339))
+	m.snapshot = st
+	// ConfigGenerators have received these services before the restart. The first refresh passes the actual changes.
+	m.previousServices = copyServices(st.Services)
+}
+
+// Executes a final refresh if Notifiers have sent events that have not been processed yet.
+func (m *Manager) flush() {
+	var events []types.RefreshEvent
+
+	for len(m.refresh) > 0 {
+		events = append(events, <-m.refresh)
+	}
+
+	if len(events) > 0 {
+		logger.Info("Executing final refresh before shutdown")
+
+		// ctx is done already. Give ServiceGenerators as much time as Quit waits for the refresh.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.Config.ShutdownTimeout)*time.Second)
+		defer cancel()
+
+		m.refreshCtx = ctx
+		m.executeRefresh(events[0], events[1:])
+	}
+}
+
+// Reconfigure executes f between two refreshes. Use it to change settings that are read during a refresh.
+// Reconfigure blocks until f has been executed or the Manager has been stopped and must only be called while Run is
+// executing.
+func (m *Manager) Reconfigure(f func()) {
+	select {
+	case m.reconfigure <- f:
+	case <-m.ctx.Done():
 	}
 }
 
-func (m *Manager) Quit() {
-	close(m.quit)
-	m.waitGroup.Wait()
+// CurrentRefreshEvent returns the event that triggered the current refresh. Components can call it from Generate to
+// learn why they are called. It must not be called outside of a refresh.
+func (m *Manager) CurrentRefreshEvent() types.RefreshEvent {
+	return m.currentEvent
+}
+
+// Executes a refresh and records its result. A successful refresh counts for readiness even in dry-run mode because
+// the Manager does all it has been configured to do.
+func (m *Manager) executeRefresh(event types.RefreshEvent, coalesced []types.RefreshEvent) error {
+	m.health.configure(m.Config)
+
+	record, err := m.runRefresh(event, coalesced, m.Config.DryRun)
+
+	if err == nil {
+		m.health.refreshSucceeded(record.Finished)
+		m.lastSuccess.Set(float64(record.Finished.Unix()))
+	}
+
+	m.refreshCounter.WithLabelValues(record.Result, event.Notifier).Inc()
+	m.history.add(record, m.Config.RefreshHistorySize)
+	m.waiters.notify(record)
+
+	return err
+}
+
+// Executes a dry run requested by a single caller. Dry runs are not recorded in the history.
+func (m *Manager) executeDryRun(event types.RefreshEvent) *RefreshRecord {
+	record, _ := m.runRefresh(event, nil, true)
+
+	return record
+}
+
+func (m *Manager) runRefresh(event types.RefreshEvent, coalesced []types.RefreshEvent, dryRun bool) (*RefreshRecord, error) {
+	m.currentEvent = event
+	m.refreshId++
+	m.refreshLog = logger.With("refresh_id", m.refreshId)
+
+	record := &RefreshRecord{Coalesced: coalesced, DryRun: dryRun, Event: event, Id: m.refreshId}
+
+	err := m.processServices(dryRun)
+
+	record.Components = m.components
+	record.Finished = time.Now()
+
+	if err != nil {
+		m.refreshLog.Error("%s", err)
+		record.Error = err.Error()
+		record.Result = "error"
+	} else {
+		record.Result = "success"
+	}
+
+	return record, err
+}
+
+// Records the outcome of calling a component during the current refresh.
+func (m *Manager) addComponentResult(kind, name string, duration time.Duration, err error) *ComponentResult {
+	result := &ComponentResult{Component: name, Duration: duration.Seconds(), Kind: kind, Success: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	m.components = append(m.components, result)
+	m.componentDuration.WithLabelValues(kind, name).Observe(duration.Seconds())
+
+	return result
+}
+
+// Quit stops all Notifiers and the HTTP server and waits for the current refresh to finish.
+// Returns an error if shutting down takes longer than ShutdownTimeout.
+func (m *Manager) Quit() error {
+	m.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.Config.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	err := m.server.Shutdown(ctx)
+	if err != nil {
+		logger.Error("Error shutting down HTTP server: %s", err)
+	}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		m.waitGroup.Wait()
+		<-m.done
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.New("Timed out waiting for Notifiers and the current refresh to finish")
+	}
+}
+
+// Consumes refresh signals until no new signal has been received for the configured quiet period or the maximum delay
+// has passed. Returns the events consumed.
+func (m *Manager) coalesce() []types.RefreshEvent {
+	if m.Config.RefreshQuietPeriod <= 0 {
+		return nil
+	}
+
+	quietPeriod := time.Duration(m.Config.RefreshQuietPeriod) * time.Millisecond
+
+	var maxDelay <-chan time.Time
+	if m.Config.RefreshMaxDelay > 0 {
+		maxDelay = time.After(time.Duration(m.Config.RefreshMaxDelay) * time.Millisecond)
+	}
+
+	timer := time.NewTimer(quietPeriod)
+	defer timer.Stop()
+
+	var events []types.RefreshEvent
+	for {
+		select {
+		case event := <-m.refresh:
+			events = append(events, event)
+			timer.Reset(quietPeriod)
+		case <-timer.C:
+			return events
+		case <-maxDelay:
+			return events
+		case <-m.ctx.Done():
+			return events
+		}
+	}
 }
 
 func (m *Manager) process() error {
-	var services []*types.Service
-	for _, sg := range m.serviceGenerators {
-		svrs, err := sg.Generate()
-		if err != nil {
+	return m.processServices(m.Config.DryRun)
+}
+
+// Generates services, annotates them and passes them to every ConfigGenerator. During a dry run, ConfigGenerators only
+// describe what they would change and the Manager keeps the state of the previous refresh.
+func (m *Manager) processServices(dryRun bool) error {
+	m.components = nil
+
+	services, records, err := m.generateServices()
+	if err != nil {
+		if m.snapshot == nil {
 			return err
 		}
 
-		services = append(services, svrs...)
+		return m.applySnapshot(err, dryRun)
+	}
+
+	services, records, err = m.prepareServices(services, records)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return m.apply(services, true)
+	}
+
+	m.services.update(records)
+	m.updateServiceGauges(services)
+
+	err = m.apply(services, false)
+	if err != nil {
+		return err
+	}
+
+	// Live data is available. The snapshot is not needed anymore.
+	m.snapshot = nil
+
+	if m.Config.StateFile != "" {
+		err := writeState(m.Config.StateFile, m.previousServices)
+		if err != nil {
+			m.refreshLog.Error("Error writing state file '%s': %s", m.Config.StateFile, err)
+		}
+	}
+
+	return nil
+}
+
+// Services calls every ServiceGenerator, Filter, Annotator and Validator once and returns the resulting services
+// without passing them to ConfigGenerators. Use it to inspect services without running the Manager. It must not be
+// called while Run is executing.
+// Notifiers are not started. Every Notifier that implements the Preloader interface loads its data beforehand.
+func (m *Manager) Services() ([]*types.Service, error) {
+	m.components = nil
+
+	for _, notifier := range m.notifiers {
+		p, ok := notifier.(types.Preloader)
+		if !ok {
+			continue
+		}
+
+		err := p.Preload()
+		if err != nil {
+			return nil, fmt.Errorf("Error preloading %s: %s", componentName(notifier), err)
+		}
+	}
+
+	services, records, err := m.generateServices()
+	if err != nil {
+		return nil, err
 	}
 
+	services, _, err = m.prepareServices(services, records)
+
+	return services, err
+}
+
+// Merges, filters, annotates and validates generated services. Returns the services that have not been rejected by a
+// Validator and a record of every service that has passed all Filters.
+func (m *Manager) prepareServices(services []*types.Service, records []*ServiceRecord) ([]*types.Service, []*ServiceRecord, error) {
+	services, records, err := mergeServices(services, records, m.Config.MergePolicy, m.Config.SourcePriority)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	services, records = m.filter(services, records)
+
 	for _, a := range m.annotators {
+		before := copyServices(services)
+		name := componentName(a)
+
+		start := time.Now()
 		err := a.Annotate(services)
+		m.addComponentResult("annotator", name, time.Since(start), err)
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+
+		for i, s := range services {
+			if fields := changedFields(before[i], s); len(fields) > 0 {
+				records[i].Annotations[name] = append(records[i].Annotations[name], fields...)
+			}
 		}
 	}
 
+	for i, s := range services {
+		records[i].Service = s.Copy()
+	}
+
+	return m.validate(services, records), records, nil
+}
+
+// Passes services to every ConfigGenerator.
+func (m *Manager) apply(services []*types.Service, dryRun bool) error {
+	changes := types.Diff(m.previousServices, services)
+	if !changes.Empty() {
+		m.refreshLog.Info("Services changed - %s", changes)
+	}
+
+	leader := m.IsLeader()
+
 	for _, cg := range m.configGenerators {
-		err := cg.Generate(services)
+		if cg.leaderOnly && !leader {
+			m.refreshLog.Debug("Not calling ConfigGenerator %s because this instance is not the leader", cg.name)
+			if !dryRun {
+				cg.skipped = true
+			}
+
+			continue
+		}
+
+		if dryRun {
+			m.dryRun(cg, services, changes)
+			continue
+		}
+
+		var err error
+
+		start := time.Now()
+
+		csg, ok := cg.generator.(types.ChangeSetConfigGenerator)
+		// The changes are unknown if no previous services exist, e.g. right after a start without a state file.
+		if ok && !cg.skipped && m.previousServices != nil {
+			err = csg.GenerateChanges(services, changes)
+		} else {
+			err = cg.generator.Generate(services)
+		}
+
+		m.addComponentResult("config_generator", cg.name, time.Since(start), err)
 		if err != nil {
 			return err
 		}
+
+		cg.skipped = false
 	}
+
+	if dryRun {
+		return nil
+	}
+
+	if !changes.Empty() {
+		m.lastChange.Set(float64(time.Now().Unix()))
+	}
+
+	// ConfigGenerators might modify services. Keep a copy to compute the changes of the next refresh.
+	m.previousServices = copyServices(services)
+
 	return nil
 }
 
+// Calls every Filter and returns the services that have been kept. records are kept aligned with the returned
+// services.
+func (m *Manager) filter(services []*types.Service, records []*ServiceRecord) ([]*types.Service, []*ServiceRecord) {
+	recordOf := make(map[*types.Service]*ServiceRecord)
+	for i, s := range services {
+		recordOf[s] = records[i]
+	}
+
+	for _, f := range m.filters {
+		name := componentName(f)
+
+		start := time.Now()
+		kept := f.Filter(services)
+		m.addComponentResult("filter", name, time.Since(start), nil)
+
+		removed := len(services) - len(kept)
+		m.filteredGauge.WithLabelValues(name).Set(float64(removed))
+		if removed > 0 {
+			m.refreshLog.Info("Filter %s removed %d services", name, removed)
+		}
+
+		services = kept
+	}
+
+	var kept []*ServiceRecord
+	for _, s := range services {
+		kept = append(kept, recordOf[s])
+	}
+
+	return services, kept
+}
+
+// Calls every Validator and returns the services that have not been rejected. The reasons of a rejection are added to
+// the ServiceRecord of the service.
+func (m *Manager) validate(services []*types.Service, records []*ServiceRecord) []*types.Service {
+	recordOf := make(map[*types.Service]*ServiceRecord)
+	for i, s := range services {
+		recordOf[s] = records[i]
+	}
+
+	for _, v := range m.validators {
+		name := componentName(v)
+
+		start := time.Now()
+		violations := v.Validate(services)
+		m.addComponentResult("validator", name, time.Since(start), nil)
+		m.rejectedGauge.WithLabelValues(name).Set(float64(len(violations)))
+
+		rejected := make(map[*types.Service]bool)
+		for _, violation := range violations {
+			m.refreshLog.With("service_id", violation.Service.Id).Error("Dropping service: %s", violation.Reason)
+			rejected[violation.Service] = true
+
+			if r, ok := recordOf[violation.Service]; ok {
+				r.Violations = append(r.Violations, violation.Reason)
+			}
+		}
+
+		var valid []*types.Service
+		for _, s := range services {
+			if !rejected[s] {
+				valid = append(valid, s)
+			}
+		}
+
+		services = valid
+	}
+
+	return services
+}
+
+// Sets the number of services and hosts per Source.
+func (m *Manager) updateServiceGauges(services []*types.Service) {
+	servicesPerSource := make(map[string]int)
+	hostsPerSource := make(map[string]int)
+
+	for _, s := range services {
+		servicesPerSource[s.Source]++
+		hostsPerSource[s.Source] += len(s.Hosts)
+	}
+
+	// Sources that do not provide any services anymore should not be reported.
+	m.servicesGauge.Reset()
+	m.hostsGauge.Reset()
+
+	for source, count := range servicesPerSource {
+		m.servicesGauge.WithLabelValues(source).Set(float64(count))
+		m.hostsGauge.WithLabelValues(source).Set(float64(hostsPerSource[source]))
+	}
+}
+
+// Asks a ConfigGenerator what it would change. ConfigGenerators that do not support dry runs are not called.
+func (m *Manager) dryRun(cg *configGenerator, services []*types.Service, changes *types.ChangeSet) {
+	drg, ok := cg.generator.(types.DryRunConfigGenerator)
+	if !ok {
+		m.refreshLog.Info("Not calling ConfigGenerator %s because it does not support dry runs", cg.name)
+		m.components = append(m.components, &ComponentResult{
+			Component: cg.name,
+			Kind:      "config_generator",
+			Output:    "dry run not supported",
+			Success:   true,
+		})
+		return
+	}
+
+	start := time.Now()
+	output, err := drg.DryRun(copyServices(services), changes)
+	result := m.addComponentResult("config_generator", cg.name, time.Since(start), err)
+	result.Output = output
+
+	if err != nil {
+		m.refreshLog.Error("Dry run of ConfigGenerator %s failed: %s", cg.name, err)
+		return
+	}
+
+	m.refreshLog.Info("Dry run of ConfigGenerator %s:\n%s", cg.name, output)
+}
+
+// Passes the services read from the state file to every ConfigGenerator because ServiceGenerators failed before a
+// refresh succeeded. The refresh is still reported as failed.
+func (m *Manager) applySnapshot(cause error, dryRun bool) error {
+	m.refreshLog.Warning("%s - using services from state file written at %s", cause, m.snapshot.Written.Format(time.RFC3339))
+
+	services := copyServices(m.snapshot.Services)
+
+	records := make([]*ServiceRecord, len(services))
+	for i, s := range services {
+		records[i] = &ServiceRecord{Annotations: make(map[string][]string), Generator: "state file", Service: s.Copy()}
+	}
+
+	if !dryRun {
+		m.services.update(records)
+	}
+
+	err := m.apply(services, dryRun)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%s - applied %d services from state file written at %s", cause, len(m.snapshot.Services), m.snapshot.Written.Format(time.RFC3339))
+}
+
+// Calls every ServiceGenerator concurrently. If a ServiceGenerator fails, its last successful result is used instead as
+// long as it is not older than ServiceCacheMaxAge.
+// Returns a ServiceRecord for every service that stores the name of the ServiceGenerator.
+func (m *Manager) generateServices() ([]*types.Service, []*ServiceRecord, error) {
+	var services []*types.Service
+	var records []*ServiceRecord
+
+	results := make([]*generateResult, len(m.serviceGenerators))
+	wg := &sync.WaitGroup{}
+
+	for i, sg := range m.serviceGenerators {
+		wg.Add(1)
+
+		go func(i int, sg *serviceGenerator) {
+			defer wg.Done()
+
+			results[i] = m.callServiceGenerator(sg)
+		}(i, sg)
+	}
+
+	wg.Wait()
+
+	for i, sg := range m.serviceGenerators {
+		m.addComponentResult("service_generator", sg.name, results[i].duration, results[i].err)
+	}
+
+	for i, sg := range m.serviceGenerators {
+		result := results[i]
+
+		if result.err != nil {
+			m.sgErrorCounter.WithLabelValues(sg.name).Inc()
+
+			cached, updatedAt, ok := m.serviceCache.get(i)
+			if !ok {
+				return nil, nil, fmt.Errorf("ServiceGenerator %s failed and no previous result is available: %s", sg.name, result.err)
+			}
+
+			age := time.Since(updatedAt)
+			if m.Config.ServiceCacheMaxAge <= 0 || age > time.Duration(m.Config.ServiceCacheMaxAge)*time.Second {
+				return nil, nil, fmt.Errorf("ServiceGenerator %s failed and its previous result from %s is stale: %s", sg.name, updatedAt.Format(time.RFC3339), result.err)
+			}
+
+			m.refreshLog.Error("ServiceGenerator %s failed: %s - using previous result from %s", sg.name, result.err, updatedAt.Format(time.RFC3339))
+			m.sgStaleness.WithLabelValues(sg.name).Set(age.Seconds())
+
+			services = append(services, cached...)
+			records = append(records, newServiceRecords(sg.name, len(cached))...)
+			continue
+		}
+
+		m.serviceCache.set(i, result.services, time.Now())
+		m.sgStaleness.WithLabelValues(sg.name).Set(0)
+
+		services = append(services, result.services...)
+		records = append(records, newServiceRecords(sg.name, len(result.services))...)
+	}
+
+	return services, records, nil
+}
+
+// Calls a ServiceGenerator and aborts the call if it exceeds ServiceGeneratorTimeout.
+func (m *Manager) callServiceGenerator(sg *serviceGenerator) *generateResult {
+	ctx := m.refreshCtx
+
+	if m.Config.ServiceGeneratorTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(m.Config.ServiceGeneratorTimeout)*time.Second)
+		defer cancel()
+	}
+
+	start := time.Now()
+
+	services, err := sg.generator.GenerateContext(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %d seconds", m.Config.ServiceGeneratorTimeout)
+	}
+
+	return &generateResult{duration: time.Since(start), services: services, err: err}
+}
+
+func newServiceRecords(generator string, n int) []*ServiceRecord {
+	records := make([]*ServiceRecord, n)
+
+	for i := range records {
+		records[i] = &ServiceRecord{Annotations: make(map[string][]string), Generator: generator}
+	}
+
+	return records
+}
+
+// Derives a name from the type of a component, e.g. "marathon.Generator", to be used in logs and metrics.
+func componentName(c interface{}) string {
+	return strings.TrimPrefix(reflect.TypeOf(c).String(), "*")
+}
+
 // Creates and returns a new Manager.
 func New() *Manager {
-	refreshChannel := make(chan string, 10)
-	quitChannel := make(chan int)
+	refreshChannel := make(chan types.RefreshEvent, 10)
 
 	refreshCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "proxym",
 		Subsystem: "refresh",
 		Name:      "count",
 		Help:      "Number of refreshes triggered",
-	}, []string{"result"})
-	prometheus.MustRegister(refreshCounter)
+	}, []string{"result", "notifier"})
+	refreshCounter = prometheus.MustRegisterOrGet(refreshCounter).(*prometheus.CounterVec)
+
+	refreshCoalesced := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "refresh",
+		Name:      "coalesced_count",
+		Help:      "Number of refresh signals coalesced into a single refresh",
+	}, []string{"notifier"})
+	refreshCoalesced = prometheus.MustRegisterOrGet(refreshCoalesced).(*prometheus.CounterVec)
+
+	sgErrorCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "service_generator",
+		Name:      "errors_count",
+		Help:      "Number of failed calls to a ServiceGenerator",
+	}, []string{"generator"})
+	sgErrorCounter = prometheus.MustRegisterOrGet(sgErrorCounter).(*prometheus.CounterVec)
+
+	sgStaleness := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "service_generator",
+		Name:      "staleness_seconds",
+		Help:      "Age of the services of a ServiceGenerator used in the last refresh. 0 if the ServiceGenerator succeeded",
+	}, []string{"generator"})
+	sgStaleness = prometheus.MustRegisterOrGet(sgStaleness).(*prometheus.GaugeVec)
+
+	componentDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "proxym",
+		Subsystem: "component",
+		Name:      "duration_seconds",
+		Help:      "Time it took to call a ServiceGenerator, Annotator or ConfigGenerator",
+	}, []string{"kind", "component"})
+	componentDuration = prometheus.MustRegisterOrGet(componentDuration).(*prometheus.HistogramVec)
+
+	servicesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Name:      "services",
+		Help:      "Number of services of the last refresh per Source",
+	}, []string{"source"})
+	servicesGauge = prometheus.MustRegisterOrGet(servicesGauge).(*prometheus.GaugeVec)
+
+	hostsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Name:      "hosts",
+		Help:      "Number of hosts of the last refresh per Source",
+	}, []string{"source"})
+	hostsGauge = prometheus.MustRegisterOrGet(hostsGauge).(*prometheus.GaugeVec)
+
+	lastSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "refresh",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful refresh",
+	})
+	lastSuccess = prometheus.MustRegisterOrGet(lastSuccess).(prometheus.Gauge)
+
+	lastChange := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "config",
+		Name:      "last_change_timestamp_seconds",
+		Help:      "Unix timestamp of the last refresh that passed changed services to ConfigGenerators",
+	})
+	lastChange = prometheus.MustRegisterOrGet(lastChange).(prometheus.Gauge)
+
+	filteredGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "filter",
+		Name:      "filtered_services",
+		Help:      "Number of services removed by a Filter during the last refresh",
+	}, []string{"filter"})
+	filteredGauge = prometheus.MustRegisterOrGet(filteredGauge).(*prometheus.GaugeVec)
+
+	rejectedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxym",
+		Subsystem: "validator",
+		Name:      "rejected_services",
+		Help:      "Number of services rejected by a Validator during the last refresh",
+	}, []string{"validator"})
+	rejectedGauge = prometheus.MustRegisterOrGet(rejectedGauge).(*prometheus.GaugeVec)
 
 	var c Config
 	envconfig.Process("proxym", &c)
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	m := &Manager{
-		Config:         &c,
-		httpRouter:     pat.New(),
-		refresh:        refreshChannel,
-		refreshCounter: refreshCounter,
-		quit:           quitChannel,
+		cancel:            cancel,
+		componentDuration: componentDuration,
+		Config:            &c,
+		ctx:               ctx,
+		done:              make(chan struct{}),
+		filteredGauge:     filteredGauge,
+		health:            newHealthState(),
+		history:           newRefreshHistory(),
+		hostsGauge:        hostsGauge,
+		httpRouter:        pat.New(),
+		lastChange:        lastChange,
+		lastSuccess:       lastSuccess,
+		reconfigure:       make(chan func()),
+		refresh:           refreshChannel,
+		refreshCoalesced:  refreshCoalesced,
+		refreshCounter:    refreshCounter,
+		refreshCtx:        ctx,
+		refreshLog:        logger,
+		rejectedGauge:     rejectedGauge,
+		serviceCache:      newServiceCache(),
+		services:          newServiceRegistry(),
+		servicesGauge:     servicesGauge,
+		sgErrorCounter:    sgErrorCounter,
+		sgStaleness:       sgStaleness,
+		waiters:           newRefreshWaiters(),
+		waitGroup:         &sync.WaitGroup{},
 	}
 
+	m.server = &http.Server{Handler: m.httpRouter}
+
 	m.httpRouter.Get("/metrics", prometheus.Handler())
+	m.RegisterHttpHandleFunc("GET", "/health", m.healthHandler)
+	m.RegisterHttpHandleFunc("GET", "/ready", m.readyHandler)
+	m.RegisterHttpHandleFunc("POST", "/refresh", m.refreshHandler)
+	m.RegisterHttpHandleFunc("GET", "/refreshes", m.history.listHandler)
+	m.RegisterHttpHandleFunc("GET", "/services", m.services.listHandler)
+	m.RegisterHttpHandleFunc("GET", "/services/:id", m.services.getHandler)
 
 	return m
 }
@@ -166,6 +1016,16 @@ func AddAnnotator(a types.Annotator) {
 	DefaultManager.AddAnnotator(a)
 }
 
+// Add a Filter.
+func AddFilter(f types.Filter) {
+	DefaultManager.AddFilter(f)
+}
+
+// Add a Validator.
+func AddValidator(v types.Validator) {
+	DefaultManager.AddValidator(v)
+}
+
 // Add a ConfigGenerator.
 func AddConfigGenerator(cg types.ConfigGenerator) {
 	DefaultManager.AddConfigGenerator(cg)
@@ -181,6 +1041,21 @@ func AddServiceGenerator(sg types.ServiceGenerator) {
 	DefaultManager.AddServiceGenerator(sg)
 }
 
+// Add a ContextServiceGenerator
+func AddContextServiceGenerator(csg types.ContextServiceGenerator) {
+	DefaultManager.AddContextServiceGenerator(csg)
+}
+
+// Add a ConfigGenerator that is only called while this instance is the leader.
+func AddLeaderConfigGenerator(cg types.ConfigGenerator) {
+	DefaultManager.AddLeaderConfigGenerator(cg)
+}
+
+// Set the Elector of the default manager.
+func SetElector(e types.Elector) {
+	DefaultManager.SetElector(e)
+}
+
 func RegisterHttpHandler(method string, path string, handle http.Handler) {
 	DefaultManager.RegisterHttpHandler(method, path, handle)
 }
@@ -194,6 +1069,7 @@ func Run() {
 	DefaultManager.Run()
 }
 
-func Quit() {
-	DefaultManager.Quit()
+// Stop the default manager.
+func Quit() error {
+	return DefaultManager.Quit()
 }
@@ -0,0 +1,456 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type serviceGeneratorMock struct {
+	err      error
+	services []*types.Service
+}
+
+func (sg *serviceGeneratorMock) Generate() ([]*types.Service, error) {
+	return sg.services, sg.err
+}
+
+type blockingServiceGenerator struct{}
+
+func (sg *blockingServiceGenerator) GenerateContext(ctx context.Context) ([]*types.Service, error) {
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+type notifierMock struct {
+	stopped bool
+}
+
+func (n *notifierMock) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	refresh <- types.NewRefreshEvent("unittest", "pending")
+
+	<-ctx.Done()
+
+	n.stopped = true
+}
+
+type annotatorMock struct{}
+
+func (a *annotatorMock) Annotate(services []*types.Service) error {
+	for _, s := range services {
+		s.Domains = append(s.Domains, "annotated.unit.test")
+	}
+
+	return nil
+}
+
+type configGeneratorMock struct {
+	services []*types.Service
+}
+
+func (cg *configGeneratorMock) Generate(services []*types.Service) error {
+	cg.services = services
+
+	return nil
+}
+
+type changeSetConfigGeneratorMock struct {
+	changes  *types.ChangeSet
+	services []*types.Service
+}
+
+func (cg *changeSetConfigGeneratorMock) Generate(services []*types.Service) error {
+	cg.changes = nil
+	cg.services = services
+
+	return nil
+}
+
+func (cg *changeSetConfigGeneratorMock) GenerateChanges(services []*types.Service, changes *types.ChangeSet) error {
+	cg.changes = changes
+	cg.services = services
+
+	return nil
+}
+
+func TestCoalesceConsumesBurstOfRefreshSignals(t *testing.T) {
+	m := New()
+	m.Config.RefreshQuietPeriod = 50
+
+	m.refresh <- types.NewRefreshEvent("unittest", "first")
+	m.refresh <- types.NewRefreshEvent("unittest", "second")
+	m.refresh <- types.NewRefreshEvent("unittest", "third")
+
+	events := m.coalesce()
+
+	require.Len(t, events, 3)
+	require.Equal(t, "first", events[0].Reason)
+	require.Len(t, m.refresh, 0)
+}
+
+func TestCoalesceStopsAfterMaxDelay(t *testing.T) {
+	m := New()
+	m.Config.RefreshMaxDelay = 100
+	m.Config.RefreshQuietPeriod = 50
+
+	stop := make(chan int)
+	defer close(stop)
+
+	go func() {
+		for {
+			select {
+			case m.refresh <- types.NewRefreshEvent("unittest", "burst"):
+				time.Sleep(10 * time.Millisecond)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	events := m.coalesce()
+
+	require.NotEmpty(t, events)
+	require.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestCoalesceDisabled(t *testing.T) {
+	m := New()
+
+	m.refresh <- types.NewRefreshEvent("unittest", "disabled")
+
+	require.Empty(t, m.coalesce())
+	require.Len(t, m.refresh, 1)
+}
+
+func TestProcessFallsBackToPreviousResultOfServiceGenerator(t *testing.T) {
+	failing := &serviceGeneratorMock{
+		services: []*types.Service{&types.Service{Id: "failing", Domains: []string{"failing.unit.test"}}},
+	}
+	healthy := &serviceGeneratorMock{
+		services: []*types.Service{&types.Service{Id: "healthy"}},
+	}
+	cg := &configGeneratorMock{}
+
+	m := New()
+	m.AddServiceGenerator(failing)
+	m.AddServiceGenerator(healthy)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(cg)
+
+	require.Nil(t, m.process())
+
+	failing.err = errors.New("unit test")
+	failing.services = nil
+	healthy.services = []*types.Service{&types.Service{Id: "healthy"}, &types.Service{Id: "healthy-new"}}
+
+	require.Nil(t, m.process())
+
+	require.Len(t, cg.services, 3)
+	require.Equal(t, "failing", cg.services[0].Id)
+	require.Equal(t, []string{"failing.unit.test", "annotated.unit.test"}, cg.services[0].Domains)
+	require.Equal(t, "healthy", cg.services[1].Id)
+	require.Equal(t, "healthy-new", cg.services[2].Id)
+}
+
+func TestProcessFailsIfPreviousResultOfServiceGeneratorIsStale(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "unittest"}}}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+
+	require.Nil(t, m.process())
+
+	m.serviceCache.set(0, sg.services, time.Now().Add(-10*time.Minute))
+	sg.err = errors.New("unit test")
+
+	require.NotNil(t, m.process())
+}
+
+func TestProcessFailsIfNoPreviousResultOfServiceGeneratorExists(t *testing.T) {
+	m := New()
+	m.AddServiceGenerator(&serviceGeneratorMock{err: errors.New("unit test")})
+
+	require.NotNil(t, m.process())
+}
+
+func TestProcessAbortsServiceGeneratorAfterTimeout(t *testing.T) {
+	m := New()
+	m.Config.ServiceGeneratorTimeout = 1
+	m.AddContextServiceGenerator(&blockingServiceGenerator{})
+	m.AddServiceGenerator(&serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "unittest"}}})
+
+	err := m.process()
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "manager.blockingServiceGenerator")
+	require.Contains(t, err.Error(), "timed out")
+}
+
+func TestProcessCancelsServiceGeneratorOnShutdown(t *testing.T) {
+	m := New()
+	m.Config.ServiceGeneratorTimeout = 60
+	m.AddContextServiceGenerator(&blockingServiceGenerator{})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		m.cancel()
+	}()
+
+	start := time.Now()
+	err := m.process()
+
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "timed out")
+	require.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestProcessPassesChangesToConfigGenerator(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+	cg := &changeSetConfigGeneratorMock{}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(cg)
+
+	// The changes of the first refresh are unknown
+	require.Nil(t, m.process())
+	require.Len(t, cg.services, 1)
+	require.Nil(t, cg.changes)
+
+	sg.services = []*types.Service{&types.Service{Id: "first"}, &types.Service{Id: "second"}}
+
+	require.Nil(t, m.process())
+	require.Len(t, cg.services, 2)
+	require.Len(t, cg.changes.Added, 1)
+	require.Equal(t, "second", cg.changes.Added[0].Id)
+	require.Len(t, cg.changes.Changed, 0)
+	require.Len(t, cg.changes.Removed, 0)
+}
+
+type electorMock struct {
+	leader bool
+}
+
+func (e *electorMock) IsLeader() bool {
+	return e.leader
+}
+
+func TestProcessCallsLeaderConfigGeneratorOnlyOnLeader(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+	cg := &configGeneratorMock{}
+	leaderCg := &changeSetConfigGeneratorMock{}
+	e := &electorMock{leader: false}
+
+	m := New()
+	m.SetElector(e)
+	m.AddServiceGenerator(sg)
+	m.AddConfigGenerator(cg)
+	m.AddLeaderConfigGenerator(leaderCg)
+
+	require.Nil(t, m.process())
+	require.Len(t, cg.services, 1)
+	require.Nil(t, leaderCg.services)
+
+	sg.services = []*types.Service{&types.Service{Id: "first"}, &types.Service{Id: "second"}}
+	e.leader = true
+
+	// Syncs all services after becoming the leader
+	require.Nil(t, m.process())
+	require.Len(t, leaderCg.services, 2)
+	require.Nil(t, leaderCg.changes)
+
+	sg.services = []*types.Service{&types.Service{Id: "second"}}
+
+	require.Nil(t, m.process())
+	require.Len(t, leaderCg.changes.Removed, 1)
+	require.Equal(t, "first", leaderCg.changes.Removed[0].Id)
+}
+
+func TestExecuteRefreshRecordsHistory(t *testing.T) {
+	sg := &serviceGeneratorMock{}
+
+	m := New()
+	m.Config.RefreshHistorySize = 2
+	m.Config.ServiceCacheMaxAge = 0
+	m.AddServiceGenerator(sg)
+
+	m.executeRefresh(types.NewRefreshEvent("unittest", "first"), nil)
+
+	sg.err = errors.New("unit test")
+	m.executeRefresh(types.NewRefreshEvent("unittest", "second"), []types.RefreshEvent{types.NewRefreshEvent("unittest", "coalesced")})
+
+	sg.err = nil
+	m.executeRefresh(types.NewRefreshEvent("unittest", "third"), nil)
+
+	req, _ := http.NewRequest("GET", "/refreshes", nil)
+	w := httptest.NewRecorder()
+
+	m.history.listHandler(w, req)
+
+	var records []*RefreshRecord
+	err := json.Unmarshal(w.Body.Bytes(), &records)
+
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "third", records[0].Event.Reason)
+	require.Equal(t, "success", records[0].Result)
+	require.Equal(t, "second", records[1].Event.Reason)
+	require.Equal(t, "error", records[1].Result)
+	require.Contains(t, records[1].Error, "unit test")
+	require.Equal(t, "coalesced", records[1].Coalesced[0].Reason)
+}
+
+func TestQuitStopsNotifiersAndFlushesPendingRefresh(t *testing.T) {
+	n := &notifierMock{}
+
+	m := New()
+	m.Config.ListenAddress = "127.0.0.1:0"
+	m.AddNotifier(n)
+
+	go m.Run()
+
+	// Give the notifier time to send its event.
+	time.Sleep(100 * time.Millisecond)
+
+	err := m.Quit()
+
+	require.Nil(t, err)
+	require.True(t, n.stopped)
+
+	records := m.history.list()
+	require.NotEmpty(t, records)
+	require.Equal(t, "pending", records[0].Event.Reason)
+}
+
+type validatorMock struct{}
+
+func (v *validatorMock) Validate(services []*types.Service) []types.Violation {
+	var violations []types.Violation
+
+	for _, s := range services {
+		if len(s.Hosts) == 0 {
+			violations = append(violations, types.Violation{Reason: "No hosts", Service: s})
+		}
+	}
+
+	return violations
+}
+
+func TestProcessDropsServicesRejectedByValidator(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{
+		&types.Service{Id: "valid", Hosts: []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}},
+		&types.Service{Id: "invalid"},
+	}}
+	cg := &configGeneratorMock{}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddValidator(&validatorMock{})
+	m.AddConfigGenerator(cg)
+
+	require.Nil(t, m.process())
+	require.Len(t, cg.services, 1)
+	require.Equal(t, "valid", cg.services[0].Id)
+
+	record, ok := m.services.get("invalid")
+	require.True(t, ok)
+	require.Equal(t, []string{"No hosts"}, record.Violations)
+	require.Equal(t, 1.0, gaugeValue(t, m.rejectedGauge.WithLabelValues("manager.validatorMock")))
+}
+
+type filterMock struct{}
+
+func (f *filterMock) Filter(services []*types.Service) []*types.Service {
+	var kept []*types.Service
+
+	for _, s := range services {
+		if s.Source != "filtered" {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+func TestProcessRemovesFilteredServices(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{
+		&types.Service{Id: "first", Source: "filtered"},
+		&types.Service{Id: "second"},
+	}}
+	cg := &configGeneratorMock{}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddFilter(&filterMock{})
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(cg)
+
+	require.Nil(t, m.process())
+	require.Len(t, cg.services, 1)
+	require.Equal(t, "second", cg.services[0].Id)
+	require.Equal(t, []string{"annotated.unit.test"}, cg.services[0].Domains)
+
+	_, ok := m.services.get("first")
+	require.False(t, ok)
+	require.Equal(t, 1.0, gaugeValue(t, m.filteredGauge.WithLabelValues("manager.filterMock")))
+}
+
+func TestServicesDoesNotCallConfigGenerators(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+	cg := &configGeneratorMock{}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(cg)
+
+	services, err := m.Services()
+
+	require.Nil(t, err)
+	require.Len(t, services, 1)
+	require.Equal(t, []string{"annotated.unit.test"}, services[0].Domains)
+	require.Nil(t, cg.services)
+	require.Empty(t, m.services.list(nil))
+}
+
+type preloaderMock struct {
+	err       error
+	preloaded bool
+}
+
+func (p *preloaderMock) Start(ctx context.Context, refresh chan types.RefreshEvent) {}
+
+func (p *preloaderMock) Preload() error {
+	p.preloaded = true
+
+	return p.err
+}
+
+func TestServicesPreloadsNotifiers(t *testing.T) {
+	p := &preloaderMock{}
+
+	m := New()
+	m.AddNotifier(&notifierMock{})
+	m.AddNotifier(p)
+	m.AddServiceGenerator(&serviceGeneratorMock{})
+
+	_, err := m.Services()
+
+	require.Nil(t, err)
+	require.True(t, p.preloaded)
+
+	p.err = errors.New("unreachable")
+
+	_, err = m.Services()
+
+	require.EqualError(t, err, "Error preloading manager.preloaderMock: unreachable")
+}
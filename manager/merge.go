@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/wndhydrnt/proxym/types"
+	"sort"
+	"strings"
+)
+
+// Policies that decide what happens if several services share the same ID.
+const (
+	// Keep all services. This is the default.
+	MergeNone = ""
+	// Keep the service of the Source with the highest priority and drop all others.
+	MergePriority = "priority"
+	// Keep the service of the Source with the highest priority and add the hosts of all others to it.
+	MergeUnion = "union"
+	// Fail the refresh.
+	MergeError = "error"
+)
+
+// Resolves services that share the same ID according to policy. priority is a comma-separated list of Sources,
+// the Source with the highest priority first. Sources that are not listed have the lowest priority. Services of equal
+// priority keep the order in which they have been generated.
+// records are kept aligned with the returned services.
+func mergeServices(services []*types.Service, records []*ServiceRecord, policy, priority string) ([]*types.Service, []*ServiceRecord, error) {
+	if policy == MergeNone {
+		return services, records, nil
+	}
+
+	if policy != MergePriority && policy != MergeUnion && policy != MergeError {
+		return nil, nil, fmt.Errorf("Unknown merge policy '%s'", policy)
+	}
+
+	rank := sourceRanks(priority)
+
+	var ids []string
+	byId := make(map[string][]int)
+	for i, s := range services {
+		if _, ok := byId[s.Id]; !ok {
+			ids = append(ids, s.Id)
+		}
+
+		byId[s.Id] = append(byId[s.Id], i)
+	}
+
+	var duplicates []string
+	var merged []*types.Service
+	var mergedRecords []*ServiceRecord
+
+	for _, id := range ids {
+		indices := byId[id]
+
+		if len(indices) > 1 {
+			sort.Stable(&byPriority{indices: indices, rank: rank, services: services})
+
+			duplicates = append(duplicates, fmt.Sprintf("%s (%s)", id, sources(services, indices)))
+		}
+
+		first := services[indices[0]]
+
+		for _, i := range indices[1:] {
+			switch policy {
+			case MergePriority:
+				logger.With("service_id", id).Info("Service of Source '%s' shadows the service of Source '%s'",
+					first.Source, services[i].Source)
+			case MergeUnion:
+				logger.With("service_id", id).Info("Adding hosts of service of Source '%s' to the service of Source '%s'",
+					services[i].Source, first.Source)
+				first.Hosts = unionHosts(first.Hosts, services[i].Hosts)
+			}
+		}
+
+		merged = append(merged, first)
+		mergedRecords = append(mergedRecords, records[indices[0]])
+	}
+
+	if policy == MergeError && len(duplicates) > 0 {
+		return nil, nil, fmt.Errorf("Services generated more than once: %s", strings.Join(duplicates, ", "))
+	}
+
+	return merged, mergedRecords, nil
+}
+
+// Returns a function that maps a Source to its position in priority.
+func sourceRanks(priority string) func(source string) int {
+	ranks := make(map[string]int)
+	for _, s := range strings.Split(priority, ",") {
+		s = strings.TrimSpace(s)
+		if _, ok := ranks[s]; s != "" && !ok {
+			ranks[s] = len(ranks)
+		}
+	}
+
+	return func(source string) int {
+		if r, ok := ranks[source]; ok {
+			return r
+		}
+
+		return len(ranks)
+	}
+}
+
+// Sorts indices of services by the priority of their Source.
+type byPriority struct {
+	indices  []int
+	rank     func(source string) int
+	services []*types.Service
+}
+
+func (b *byPriority) Len() int { return len(b.indices) }
+func (b *byPriority) Less(i, j int) bool {
+	return b.rank(b.services[b.indices[i]].Source) < b.rank(b.services[b.indices[j]].Source)
+}
+func (b *byPriority) Swap(i, j int) { b.indices[i], b.indices[j] = b.indices[j], b.indices[i] }
+
+func sources(services []*types.Service, indices []int) string {
+	var s []string
+	for _, i := range indices {
+		s = append(s, services[i].Source)
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// Appends every host of add that is not part of hosts yet.
+func unionHosts(hosts, add []types.Host) []types.Host {
+	result := append([]types.Host(nil), hosts...)
+
+	for _, h := range add {
+		found := false
+		for _, existing := range result {
+			if existing == h {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			result = append(result, h)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"testing"
+)
+
+func duplicateServices() ([]*types.Service, []*ServiceRecord) {
+	services := []*types.Service{
+		&types.Service{Id: "webapp", Source: "Marathon", Hosts: []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}},
+		&types.Service{Id: "other", Source: "Marathon"},
+		&types.Service{Id: "webapp", Source: "File", Config: "override", Hosts: []types.Host{
+			types.Host{Ip: "10.10.10.10", Port: 31001},
+			types.Host{Ip: "10.10.10.11", Port: 31002},
+		}},
+	}
+
+	records := append(newServiceRecords("marathon.Generator", 2), newServiceRecords("file.Generator", 1)...)
+
+	return services, records
+}
+
+func TestMergeServicesKeepsAllServicesByDefault(t *testing.T) {
+	services, records := duplicateServices()
+
+	merged, mergedRecords, err := mergeServices(services, records, MergeNone, "File,Marathon")
+
+	require.Nil(t, err)
+	require.Len(t, merged, 3)
+	require.Len(t, mergedRecords, 3)
+}
+
+func TestMergeServicesPriority(t *testing.T) {
+	services, records := duplicateServices()
+
+	merged, mergedRecords, err := mergeServices(services, records, MergePriority, "File, Marathon")
+
+	require.Nil(t, err)
+	require.Len(t, merged, 2)
+	require.Equal(t, "webapp", merged[0].Id)
+	require.Equal(t, "File", merged[0].Source)
+	require.Equal(t, "override", merged[0].Config)
+	require.Len(t, merged[0].Hosts, 2)
+	require.Equal(t, "file.Generator", mergedRecords[0].Generator)
+	require.Equal(t, "other", merged[1].Id)
+	require.Equal(t, "marathon.Generator", mergedRecords[1].Generator)
+}
+
+func TestMergeServicesPriorityPrefersListedSources(t *testing.T) {
+	services, records := duplicateServices()
+
+	merged, _, err := mergeServices(services, records, MergePriority, "File")
+
+	require.Nil(t, err)
+	require.Equal(t, "File", merged[0].Source)
+
+	services, records = duplicateServices()
+
+	merged, _, err = mergeServices(services, records, MergePriority, "")
+
+	require.Nil(t, err)
+	require.Equal(t, "Marathon", merged[0].Source)
+}
+
+func TestMergeServicesUnion(t *testing.T) {
+	services, records := duplicateServices()
+
+	merged, _, err := mergeServices(services, records, MergeUnion, "Marathon,File")
+
+	require.Nil(t, err)
+	require.Len(t, merged, 2)
+	require.Equal(t, "Marathon", merged[0].Source)
+	require.Equal(t, "", merged[0].Config)
+	require.Equal(t, []types.Host{
+		types.Host{Ip: "10.10.10.10", Port: 31001},
+		types.Host{Ip: "10.10.10.11", Port: 31002},
+	}, merged[0].Hosts)
+}
+
+func TestMergeServicesError(t *testing.T) {
+	services, records := duplicateServices()
+
+	_, _, err := mergeServices(services, records, MergeError, "File,Marathon")
+
+	require.NotNil(t, err)
+	require.Equal(t, "Services generated more than once: webapp (File, Marathon)", err.Error())
+}
+
+func TestMergeServicesUnknownPolicy(t *testing.T) {
+	services, records := duplicateServices()
+
+	_, _, err := mergeServices(services, records, "unknown", "")
+
+	require.NotNil(t, err)
+}
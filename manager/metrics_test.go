@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"testing"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	require.Nil(t, g.Write(m))
+
+	return m.GetGauge().GetValue()
+}
+
+func TestProcessUpdatesMetrics(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{
+		&types.Service{Id: "first", Hosts: []types.Host{types.Host{}, types.Host{}}, Source: "Marathon"},
+		&types.Service{Id: "second", Hosts: []types.Host{types.Host{}}, Source: "Marathon"},
+		&types.Service{Id: "third", Hosts: []types.Host{types.Host{}}, Source: "Mesos Master"},
+	}}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddConfigGenerator(&configGeneratorMock{})
+
+	m.executeRefresh(types.NewRefreshEvent("unittest", "metrics"), nil)
+
+	require.Equal(t, float64(2), gaugeValue(t, m.servicesGauge.WithLabelValues("Marathon")))
+	require.Equal(t, float64(3), gaugeValue(t, m.hostsGauge.WithLabelValues("Marathon")))
+	require.Equal(t, float64(1), gaugeValue(t, m.servicesGauge.WithLabelValues("Mesos Master")))
+	require.NotEqual(t, float64(0), gaugeValue(t, m.lastSuccess))
+	require.NotEqual(t, float64(0), gaugeValue(t, m.lastChange))
+
+	h := &dto.Metric{}
+	require.Nil(t, m.componentDuration.WithLabelValues("config_generator", "manager.configGeneratorMock").Write(h))
+	require.NotEqual(t, uint64(0), h.GetHistogram().GetSampleCount())
+}
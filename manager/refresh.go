@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Keeps track of callers waiting for the refresh that processes a RefreshEvent.
+type refreshWaiters struct {
+	mutex   *sync.Mutex
+	waiters map[types.RefreshEvent]chan *RefreshRecord
+}
+
+// Returns a channel that receives the record of the refresh that processes event.
+func (rw *refreshWaiters) add(event types.RefreshEvent) chan *RefreshRecord {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	c := make(chan *RefreshRecord, 1)
+	rw.waiters[event] = c
+
+	return c
+}
+
+func (rw *refreshWaiters) remove(event types.RefreshEvent) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	delete(rw.waiters, event)
+}
+
+// Passes record to everyone waiting for the event that triggered the refresh or for one of the coalesced events.
+func (rw *refreshWaiters) notify(record *RefreshRecord) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	events := append([]types.RefreshEvent{record.Event}, record.Coalesced...)
+
+	for _, e := range events {
+		if c, ok := rw.waiters[e]; ok {
+			c <- record
+			delete(rw.waiters, e)
+		}
+	}
+}
+
+func newRefreshWaiters() *refreshWaiters {
+	return &refreshWaiters{mutex: &sync.Mutex{}, waiters: make(map[types.RefreshEvent]chan *RefreshRecord)}
+}
+
+// Triggers a refresh. The parameter "reason" describes why the refresh has been requested.
+// If the parameter "wait" is true, the handler waits for the refresh to finish and responds with its RefreshRecord.
+// If the parameter "dry_run" is true, ConfigGenerators only describe what they would change. A dry run always waits.
+// The status code is 500 if the refresh failed.
+func (m *Manager) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "requested via HTTP"
+	}
+
+	event := types.NewRefreshEvent("http", reason)
+
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+	if dryRun {
+		m.dryRunHandler(w, r, event)
+		return
+	}
+
+	wait, _ := strconv.ParseBool(r.FormValue("wait"))
+
+	var result chan *RefreshRecord
+	if wait {
+		result = m.waiters.add(event)
+		defer m.waiters.remove(event)
+	}
+
+	select {
+	case m.refresh <- event:
+	case <-m.ctx.Done():
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	if !wait {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	select {
+	case record := <-result:
+		status := http.StatusOK
+		if record.Result != "success" {
+			status = http.StatusInternalServerError
+		}
+
+		writeJSON(w, status, record)
+	case <-m.done:
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// Executes a dry run between two refreshes and responds with its RefreshRecord.
+func (m *Manager) dryRunHandler(w http.ResponseWriter, r *http.Request, event types.RefreshEvent) {
+	result := make(chan *RefreshRecord, 1)
+
+	select {
+	case m.reconfigure <- func() { result <- m.executeDryRun(event) }:
+	case <-m.ctx.Done():
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	record := <-result
+
+	status := http.StatusOK
+	if record.Result != "success" {
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, record)
+}
@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshHandlerWaitsForRefresh(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+
+	m := New()
+	m.Config.ListenAddress = "127.0.0.1:0"
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(&configGeneratorMock{})
+
+	go m.Run()
+	defer m.Quit()
+
+	req, _ := http.NewRequest("POST", "/refresh?reason=deployment&wait=true", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	record := &RefreshRecord{}
+	err := json.Unmarshal(w.Body.Bytes(), record)
+
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "http", record.Event.Notifier)
+	require.Equal(t, "deployment", record.Event.Reason)
+	require.Equal(t, "success", record.Result)
+	require.Len(t, record.Components, 3)
+	require.Equal(t, "service_generator", record.Components[0].Kind)
+	require.Equal(t, "manager.serviceGeneratorMock", record.Components[0].Component)
+	require.Equal(t, "annotator", record.Components[1].Kind)
+	require.Equal(t, "config_generator", record.Components[2].Kind)
+	require.True(t, record.Components[2].Success)
+
+	sg.err = errors.New("unit test")
+	m.Config.ServiceCacheMaxAge = 0
+
+	req, _ = http.NewRequest("POST", "/refresh?wait=true", nil)
+	w = httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	err = json.Unmarshal(w.Body.Bytes(), record)
+
+	require.Nil(t, err)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, "error", record.Result)
+	require.Len(t, record.Components, 1)
+	require.False(t, record.Components[0].Success)
+	require.Equal(t, "unit test", record.Components[0].Error)
+}
+
+func TestRefreshHandlerDoesNotWait(t *testing.T) {
+	m := New()
+
+	req, _ := http.NewRequest("POST", "/refresh?reason=deployment", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	event := <-m.refresh
+	require.Equal(t, "deployment", event.Reason)
+}
+
+type dryRunConfigGeneratorMock struct {
+	configGeneratorMock
+	dryRunServices []*types.Service
+}
+
+func (cg *dryRunConfigGeneratorMock) DryRun(services []*types.Service, changes *types.ChangeSet) (string, error) {
+	cg.dryRunServices = services
+
+	return "would change", nil
+}
+
+func TestRefreshHandlerDryRun(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+	cg := &configGeneratorMock{}
+	drcg := &dryRunConfigGeneratorMock{}
+
+	m := New()
+	m.Config.ListenAddress = "127.0.0.1:0"
+	m.AddServiceGenerator(sg)
+	m.AddConfigGenerator(cg)
+	m.AddConfigGenerator(drcg)
+
+	go m.Run()
+	defer m.Quit()
+
+	req, _ := http.NewRequest("POST", "/refresh?dry_run=true", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	record := &RefreshRecord{}
+	err := json.Unmarshal(w.Body.Bytes(), record)
+
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, record.DryRun)
+	require.Len(t, record.Components, 3)
+	require.Equal(t, "dry run not supported", record.Components[1].Output)
+	require.Equal(t, "would change", record.Components[2].Output)
+	require.Len(t, drcg.dryRunServices, 1)
+}
+
+func TestProcessInDryRunModeKeepsState(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+	cg := &dryRunConfigGeneratorMock{}
+
+	m := New()
+	m.Config.DryRun = true
+	m.AddServiceGenerator(sg)
+	m.AddConfigGenerator(cg)
+
+	require.Nil(t, m.process())
+	require.Nil(t, cg.services)
+	require.Len(t, cg.dryRunServices, 1)
+	require.Nil(t, m.previousServices)
+	require.Len(t, m.services.list(nil), 0)
+}
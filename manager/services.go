@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A ServiceRecord describes a service generated during the most recent refresh.
+type ServiceRecord struct {
+	// Fields changed by each Annotator, keyed by the name of the Annotator.
+	Annotations map[string][]string `json:"annotations,omitempty"`
+	// Name of the ServiceGenerator that produced the service.
+	Generator string         `json:"generator"`
+	Service   *types.Service `json:"service"`
+	// Last time the service or its annotations changed.
+	Updated time.Time `json:"updated"`
+	// Reasons why Validators rejected the service. A rejected service is not passed to ConfigGenerators.
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Keeps the services generated during the most recent refresh.
+type serviceRegistry struct {
+	mutex   *sync.Mutex
+	records []*ServiceRecord
+}
+
+// Replaces all records. A record keeps its update time if neither the service nor its annotations have changed.
+func (sr *serviceRegistry) update(records []*ServiceRecord) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	previous := make(map[string]*ServiceRecord)
+	for _, r := range sr.records {
+		previous[r.Service.Id] = r
+	}
+
+	now := time.Now()
+
+	for _, r := range records {
+		p, ok := previous[r.Service.Id]
+		if ok && p.Generator == r.Generator && reflect.DeepEqual(p.Service, r.Service) &&
+			reflect.DeepEqual(p.Annotations, r.Annotations) && reflect.DeepEqual(p.Violations, r.Violations) {
+			r.Updated = p.Updated
+		} else {
+			r.Updated = now
+		}
+	}
+
+	sr.records = records
+}
+
+// Returns all records that match the filters in query.
+func (sr *serviceRegistry) list(query url.Values) []*ServiceRecord {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	records := []*ServiceRecord{}
+
+	for _, r := range sr.records {
+		if matchesQuery(r.Service, query) {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+func (sr *serviceRegistry) get(id string) (*ServiceRecord, bool) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	for _, r := range sr.records {
+		if r.Service.Id == id {
+			return r, true
+		}
+	}
+
+	return nil, false
+}
+
+func (sr *serviceRegistry) listHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sr.list(r.URL.Query()))
+}
+
+func (sr *serviceRegistry) getHandler(w http.ResponseWriter, r *http.Request) {
+	record, ok := sr.get(r.URL.Query().Get(":id"))
+	if !ok {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+// Reports whether a service matches the filters "source", "domain" and "protocol". "protocol" matches the
+// application protocol as well as the transport protocol of a service.
+func matchesQuery(s *types.Service, query url.Values) bool {
+	if source := query.Get("source"); source != "" && s.Source != source {
+		return false
+	}
+
+	if protocol := query.Get("protocol"); protocol != "" &&
+		s.ApplicationProtocol != protocol && s.TransportProtocol != protocol {
+		return false
+	}
+
+	if domain := query.Get("domain"); domain != "" {
+		for _, d := range s.Domains {
+			if d == domain {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Returns the names of the fields that differ between two versions of a service.
+func changedFields(previous, current *types.Service) []string {
+	var fields []string
+
+	p := reflect.ValueOf(previous).Elem()
+	c := reflect.ValueOf(current).Elem()
+
+	for i := 0; i < p.NumField(); i++ {
+		if !reflect.DeepEqual(p.Field(i).Interface(), c.Field(i).Interface()) {
+			fields = append(fields, p.Type().Field(i).Name)
+		}
+	}
+
+	return fields
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Error marshalling response: '%s'", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func newServiceRegistry() *serviceRegistry {
+	return &serviceRegistry{mutex: &sync.Mutex{}}
+}
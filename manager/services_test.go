@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServicesEndpoints(t *testing.T) {
+	sg := &serviceGeneratorMock{services: []*types.Service{
+		&types.Service{Id: "first", ApplicationProtocol: "http", Source: "Marathon"},
+		&types.Service{Id: "second", TransportProtocol: "tcp", Source: "Mesos Master"},
+	}}
+
+	m := New()
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+
+	require.Nil(t, m.process())
+
+	req, _ := http.NewRequest("GET", "/services?source=Marathon", nil)
+	w := httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	var records []*ServiceRecord
+	err := json.Unmarshal(w.Body.Bytes(), &records)
+
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "first", records[0].Service.Id)
+	require.Equal(t, "manager.serviceGeneratorMock", records[0].Generator)
+	require.Equal(t, []string{"Domains"}, records[0].Annotations["manager.annotatorMock"])
+
+	req, _ = http.NewRequest("GET", "/services?protocol=tcp&domain=annotated.unit.test", nil)
+	w = httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	err = json.Unmarshal(w.Body.Bytes(), &records)
+
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "second", records[0].Service.Id)
+
+	req, _ = http.NewRequest("GET", "/services/second", nil)
+	w = httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	record := &ServiceRecord{}
+	err = json.Unmarshal(w.Body.Bytes(), record)
+
+	require.Nil(t, err)
+	require.Equal(t, "second", record.Service.Id)
+
+	req, _ = http.NewRequest("GET", "/services/unknown", nil)
+	w = httptest.NewRecorder()
+	m.httpRouter.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServiceRegistryKeepsUpdateTimeOfUnchangedServices(t *testing.T) {
+	sr := newServiceRegistry()
+
+	sr.update([]*ServiceRecord{
+		&ServiceRecord{Generator: "unittest", Service: &types.Service{Id: "first"}},
+		&ServiceRecord{Generator: "unittest", Service: &types.Service{Id: "second"}},
+	})
+
+	first, _ := sr.get("first")
+	updated := first.Updated
+
+	time.Sleep(time.Millisecond)
+
+	sr.update([]*ServiceRecord{
+		&ServiceRecord{Generator: "unittest", Service: &types.Service{Id: "first"}},
+		&ServiceRecord{Generator: "unittest", Service: &types.Service{Id: "second", Port: 80}},
+	})
+
+	first, _ = sr.get("first")
+	second, _ := sr.get("second")
+
+	require.Equal(t, updated, first.Updated)
+	require.True(t, second.Updated.After(updated))
+}
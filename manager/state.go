@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The content of the state file.
+type state struct {
+	Services []*types.Service `json:"services"`
+	Written  time.Time        `json:"written"`
+}
+
+func readState(path string) (*state, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &state{}
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Writes the state file. The data is written to a temporary file first which then replaces the state file so that a
+// crash does not leave a partially written state file behind.
+func writeState(path string, services []*types.Service) error {
+	data, err := json.Marshal(&state{Services: services, Written: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
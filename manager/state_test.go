@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessBootstrapsFromStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}}}
+
+	m := New()
+	m.Config.StateFile = stateFile
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(&configGeneratorMock{})
+
+	require.Nil(t, m.process())
+
+	// Simulate a restart while the ServiceGenerator is unavailable
+	sg.err = errors.New("unavailable")
+	cg := &configGeneratorMock{}
+
+	m = New()
+	m.Config.StateFile = stateFile
+	m.AddServiceGenerator(sg)
+	m.AddAnnotator(&annotatorMock{})
+	m.AddConfigGenerator(cg)
+	m.loadState()
+
+	err = m.process()
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "state file")
+	require.Len(t, cg.services, 1)
+	require.Equal(t, "first", cg.services[0].Id)
+	require.Equal(t, []string{"annotated.unit.test"}, cg.services[0].Domains)
+
+	sg.err = nil
+	sg.services = []*types.Service{&types.Service{Id: "second"}}
+
+	require.Nil(t, m.process())
+	require.Nil(t, m.snapshot)
+	require.Len(t, cg.services, 1)
+	require.Equal(t, "second", cg.services[0].Id)
+
+	st, err := readState(stateFile)
+	require.Nil(t, err)
+	require.Len(t, st.Services, 1)
+	require.Equal(t, "second", st.Services[0].Id)
+}
+
+func TestProcessPassesChangesSinceStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	require.Nil(t, writeState(stateFile, []*types.Service{&types.Service{Id: "first"}, &types.Service{Id: "second"}}))
+
+	sg := &serviceGeneratorMock{services: []*types.Service{&types.Service{Id: "first"}, &types.Service{Id: "third"}}}
+	cg := &changeSetConfigGeneratorMock{}
+
+	m := New()
+	m.Config.StateFile = stateFile
+	m.AddServiceGenerator(sg)
+	m.AddConfigGenerator(cg)
+	m.loadState()
+
+	require.Nil(t, m.process())
+	require.Len(t, cg.changes.Added, 1)
+	require.Equal(t, "third", cg.changes.Added[0].Id)
+	require.Len(t, cg.changes.Removed, 1)
+	require.Equal(t, "second", cg.changes.Removed[0].Id)
+}
+
+func TestLoadStateIgnoresMissingFile(t *testing.T) {
+	m := New()
+	m.Config.StateFile = "/does/not/exist/state.json"
+	m.loadState()
+
+	require.Nil(t, m.snapshot)
+}
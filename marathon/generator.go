@@ -1,9 +1,9 @@
 package marathon
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/types"
 	"github.com/wndhydrnt/proxym/utils"
 	"io/ioutil"
@@ -21,52 +21,47 @@ type Generator struct {
 
 // Generate queries a Marathon master to receive running applications and tasks and generates a list of services.
 func (g *Generator) Generate() ([]*types.Service, error) {
+	return g.GenerateContext(context.Background())
+}
+
+// GenerateContext works like Generate but aborts all requests to Marathon once ctx is done.
+func (g *Generator) GenerateContext(ctx context.Context) ([]*types.Service, error) {
 	var apps Apps
 	var tasks Tasks
 
 	server := utils.PickRandomFromList(g.marathonServers)
 
-	log.AppLog.Debug("Querying Marathon server at '%s'", server)
+	logger.Debug("Querying Marathon server at '%s'", server)
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("%s%s", server, appsEndpoint), nil)
-	req.Header.Add("Accept", "application/json")
-
-	resp, err := g.httpClient.Do(req)
+	err := g.get(ctx, server+appsEndpoint, &apps)
 	if err != nil {
 		return []*types.Service{}, err
 	}
-	defer resp.Body.Close()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	err = g.get(ctx, server+tasksEndpoint, &tasks)
 	if err != nil {
 		return []*types.Service{}, err
 	}
 
-	err = json.Unmarshal(data, &apps)
-	if err != nil {
-		return []*types.Service{}, err
-	}
+	return g.servicesFromMarathon(apps, tasks), nil
+}
 
-	req, _ = http.NewRequest("GET", fmt.Sprintf("%s%s", server, tasksEndpoint), nil)
+func (g *Generator) get(ctx context.Context, url string, v interface{}) error {
+	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Add("Accept", "application/json")
 
-	resp, err = g.httpClient.Do(req)
+	resp, err := g.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return []*types.Service{}, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	data, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return []*types.Service{}, err
-	}
-
-	err = json.Unmarshal(data, &tasks)
+	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return []*types.Service{}, err
+		return err
 	}
 
-	return g.servicesFromMarathon(apps, tasks), nil
+	return json.Unmarshal(data, v)
 }
 
 func (g *Generator) servicesFromMarathon(apps Apps, tasks Tasks) []*types.Service {
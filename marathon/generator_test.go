@@ -1,6 +1,7 @@
 package marathon
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/require"
 	"github.com/wndhydrnt/proxym/types"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestServicesFromMarathon(t *testing.T) {
@@ -237,3 +239,31 @@ func TestShouldNotConsiderAppsWithoutPorts(t *testing.T) {
 
 	require.Empty(t, services)
 }
+
+func TestGenerateContextAbortsRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+
+	defer ts.Close()
+
+	generator := Generator{
+		httpClient:      &http.Client{},
+		marathonServers: []string{ts.URL},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := generator.GenerateContext(ctx)
+
+	require.NotNil(t, err)
+	require.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestNewServiceGeneratorRequiresServers(t *testing.T) {
+	_, err := NewServiceGenerator(&Config{})
+
+	require.NotNil(t, err)
+}
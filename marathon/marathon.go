@@ -2,13 +2,15 @@ package marathon
 
 import (
 	"errors"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
-	"log"
+	"github.com/wndhydrnt/proxym/module"
 	"net/http"
 	"strings"
 )
 
+var logger = log.Module("marathon")
+
 const (
 	appsEndpoint              = "/v2/apps"
 	contentType               = "application/json; charset=utf-8"
@@ -35,6 +37,11 @@ type Config struct {
 	Servers string
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
 // Container as returned by the Marathon REST API.
 type Container struct {
 	Docker Docker
@@ -71,46 +78,60 @@ type Tasks struct {
 	Tasks []Task
 }
 
-// NewNotifier creates and returns a new Notifier
-func NewNotifier(c *Config) *Watcher {
+// NewNotifier creates and returns a new Notifier. listenAddress is the address of the HTTP server of the Manager
+// which receives callbacks from Marathon.
+func NewNotifier(c *Config, listenAddress string) *Watcher {
 	httpClient := &http.Client{}
 
 	return &Watcher{
 		config:            c,
 		httpClient:        httpClient,
-		httpListenAddress: manager.DefaultManager.Config.ListenAddress,
+		httpListenAddress: listenAddress,
 	}
 }
 
 // NewServiceGenerator creates and returns a new ServiceGenerator.
 func NewServiceGenerator(c *Config) (*Generator, error) {
-	httpClient := &http.Client{}
-	marathonServers := strings.Split(c.Servers, ",")
-
-	if len(marathonServers) == 0 {
+	if c.Servers == "" {
 		return nil, errors.New("PROXYM_MARATHON_SERVERS not set")
 	}
 
+	httpClient := &http.Client{}
+	marathonServers := strings.Split(c.Servers, ",")
+
 	return &Generator{config: c, httpClient: httpClient, marathonServers: marathonServers}, nil
 }
 
-func init() {
-	var c Config
+func validate(mc module.Config) error {
+	if mc.(*Config).Servers == "" {
+		return errors.New("PROXYM_MARATHON_SERVERS not set")
+	}
+
+	return nil
+}
 
-	envconfig.Process("proxym_marathon", &c)
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
 
-	if c.Enabled {
-		n := NewNotifier(&c)
+	sg, err := NewServiceGenerator(c)
+	if err != nil {
+		return err
+	}
 
-		manager.AddNotifier(n)
+	n := NewNotifier(c, m.Config.ListenAddress)
 
-		manager.RegisterHttpHandleFunc("POST", "/marathon/callback", n.callbackHandler)
+	m.AddNotifier(n)
+	m.RegisterHttpHandleFunc("POST", "/marathon/callback", n.callbackHandler)
+	m.AddServiceGenerator(sg)
 
-		sg, err := NewServiceGenerator(&c)
-		if err != nil {
-			log.Fatalln(err)
-		}
+	return nil
+}
 
-		manager.AddServiceGenerator(sg)
-	}
+func init() {
+	module.Register(&module.Module{
+		Name:      "marathon",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
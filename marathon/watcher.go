@@ -2,13 +2,13 @@ package marathon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/wndhydrnt/proxym/log"
+	"github.com/wndhydrnt/proxym/types"
 	"io/ioutil"
 	"net/http"
 	"strings"
-	"sync"
 )
 
 // Receives messages via the HTTP event bus of Marathon.
@@ -16,13 +16,12 @@ type Watcher struct {
 	config            *Config
 	httpClient        *http.Client
 	httpListenAddress string
-	refreshChannel    chan string
+	refreshChannel    chan types.RefreshEvent
 }
 
-// Start a HTTP server and register its endpoint with Marathon in order to receive event notifications.
-func (wt *Watcher) Start(refresh chan string, quit chan int, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+// Start registers an endpoint with Marathon in order to receive event notifications.
+// The registration is removed once ctx is done. Start returns immediately if the registration fails.
+func (wt *Watcher) Start(ctx context.Context, refresh chan types.RefreshEvent) {
 	wt.refreshChannel = refresh
 
 	server := strings.Split(wt.config.Servers, ",")[0]
@@ -33,13 +32,26 @@ func (wt *Watcher) Start(refresh chan string, quit chan int, wg *sync.WaitGroup)
 
 	resp, err := wt.httpClient.Post(url, contentType, bytes.NewBufferString(""))
 	if err != nil {
-		log.ErrorLog.Error("Error registering callback with Marathon '%s'", err)
+		logger.Error("Error registering callback with Marathon '%s'", err)
 		return
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		log.ErrorLog.Error("Unable to register callback with Marathon server '%s''", server)
+		logger.Error("Unable to register callback with Marathon server '%s''", server)
+		return
+	}
+
+	<-ctx.Done()
+
+	req, _ := http.NewRequest("DELETE", url, nil)
+
+	resp, err = wt.httpClient.Do(req)
+	if err != nil {
+		logger.Error("Error removing callback from Marathon '%s'", err)
+		return
 	}
+	resp.Body.Close()
 }
 
 func (wt *Watcher) callbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,20 +59,20 @@ func (wt *Watcher) callbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.ErrorLog.Error("Error reading Marathon event '%s'", err)
+		logger.Error("Error reading Marathon event '%s'", err)
 		return
 	}
 
 	err = json.Unmarshal(body, &event)
 	if err != nil {
-		log.ErrorLog.Error("Error unmarshalling Marathon event '%s'", err)
+		logger.Error("Error unmarshalling Marathon event '%s'", err)
 		return
 	}
 
 	if event.EventType == "status_update_event" {
 		select {
-		case wt.refreshChannel <- "refresh":
-			log.AppLog.Info("Triggering refresh")
+		case wt.refreshChannel <- types.NewRefreshEvent("marathon", event.EventType):
+			logger.Info("Triggering refresh")
 		default:
 		}
 	}
@@ -2,23 +2,22 @@ package marathon
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"sync"
 	"testing"
 )
 
 func TestShouldRegisterWithMarathon(t *testing.T) {
-	callReceived := false
-	refresh := make(chan string, 1)
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
+	var methods []string
+	refresh := make(chan types.RefreshEvent, 1)
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		callReceived = true
+		methods = append(methods, r.Method)
 
 		w.WriteHeader(http.StatusOK)
 
@@ -35,14 +34,17 @@ func TestShouldRegisterWithMarathon(t *testing.T) {
 		httpClient: c,
 	}
 
-	watcher.Start(refresh, make(chan int), wg)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	require.True(t, callReceived)
+	watcher.Start(ctx, refresh)
+
+	require.Equal(t, []string{"POST", "DELETE"}, methods)
 }
 
 func TestReactsToStatusUpdateEvent(t *testing.T) {
 	event := bytes.NewBufferString(`{"eventType": "status_update_event"}`)
-	refresh := make(chan string, 1)
+	refresh := make(chan types.RefreshEvent, 1)
 
 	req, err := http.NewRequest("POST", "http://localhost:9000/callback", event)
 	if err != nil {
@@ -63,10 +65,9 @@ func TestReactsToStatusUpdateEvent(t *testing.T) {
 	require.Equal(t, "", w.Body.String())
 
 	select {
-	case msg := <-refresh:
-		if msg != "refresh" {
-			require.FailNow(t, "Expect message from refresh channel to be of value 'refresh'")
-		}
+	case event := <-refresh:
+		require.Equal(t, "marathon", event.Notifier)
+		require.Equal(t, "status_update_event", event.Reason)
 	default:
 		require.FailNow(t, "Expect to receive message from refresh channel")
 	}
@@ -74,7 +75,7 @@ func TestReactsToStatusUpdateEvent(t *testing.T) {
 
 func TestIgnoresDifferentEvent(t *testing.T) {
 	event := bytes.NewBufferString(`{"eventType": "failed_health_check_event"}`)
-	refresh := make(chan string, 1)
+	refresh := make(chan types.RefreshEvent, 1)
 
 	req, err := http.NewRequest("POST", "http://localhost:9000/callback", event)
 	if err != nil {
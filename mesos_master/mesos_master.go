@@ -4,9 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
 	"github.com/wndhydrnt/proxym/utils"
 	"io/ioutil"
@@ -19,10 +18,15 @@ import (
 )
 
 type Config struct {
-	Domain       string
+	Domain       string `reload:"true"`
 	Enabled      bool
 	Masters      string
-	PollInterval int `env_config:"poll_interval"`
+	PollInterval int `envconfig:"poll_interval"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
 }
 
 type leaderRegistry struct {
@@ -127,32 +131,42 @@ func sanitizeConfig(c *Config) error {
 	return nil
 }
 
-func init() {
-	var c Config
-
-	envconfig.Process("proxym_mesos_master", &c)
-
-	if c.Enabled {
-		err := sanitizeConfig(&c)
-		if err != nil {
-			log.ErrorLog.Critical("Not initializing module Mesos Master: '%s'", err)
-			return
-		}
-
-		lr := &leaderRegistry{
-			mutex: &sync.Mutex{},
-		}
-
-		n, err := NewMesosNotifier(&c, lr)
-		if err != nil {
-			log.ErrorLog.Fatal(err)
-		}
-		manager.AddNotifier(n)
-
-		sg := &MesosMasterServiceGenerator{
-			config:         &c,
-			leaderRegistry: lr,
-		}
-		manager.AddServiceGenerator(sg)
+func validate(mc module.Config) error {
+	return sanitizeConfig(mc.(*Config))
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
+
+	err := sanitizeConfig(c)
+	if err != nil {
+		return err
 	}
+
+	lr := &leaderRegistry{
+		mutex: &sync.Mutex{},
+	}
+
+	n, err := NewMesosNotifier(c, lr)
+	if err != nil {
+		return err
+	}
+	m.AddNotifier(n)
+
+	sg := &MesosMasterServiceGenerator{
+		config:         c,
+		leaderRegistry: lr,
+	}
+	m.AddServiceGenerator(sg)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "mesos_master",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
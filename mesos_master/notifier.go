@@ -1,15 +1,18 @@
 package mesos_master
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/types"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
+var logger = log.Module("mesos_master")
+
 type MesosMasterNotifier struct {
 	config         *Config
 	currentLeader  types.Host
@@ -18,23 +21,39 @@ type MesosMasterNotifier struct {
 	masters        []string
 }
 
-func (m *MesosMasterNotifier) Start(refresh chan string, quit chan int, wg *sync.WaitGroup) {
-	// No need to close anything
-	wg.Done()
-
+func (m *MesosMasterNotifier) Start(ctx context.Context, refresh chan types.RefreshEvent) {
 	m.pollLeader(refresh)
 
-	c := time.Tick(time.Duration(m.config.PollInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(m.config.PollInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.pollLeader(refresh)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	for _ = range c {
-		m.pollLeader(refresh)
+// Preload implements the Preloader interface. It queries the current leader once.
+func (m *MesosMasterNotifier) Preload() error {
+	host, err := leader(m.hc, m.masters)
+	if err != nil {
+		return fmt.Errorf("Error getting current Mesos Master leader: %s", err)
 	}
+
+	m.leaderRegistry.set(host)
+	m.currentLeader = host
+
+	return nil
 }
 
-func (m *MesosMasterNotifier) pollLeader(refresh chan string) {
+func (m *MesosMasterNotifier) pollLeader(refresh chan types.RefreshEvent) {
 	host, err := leader(m.hc, m.masters)
 	if err != nil {
-		log.ErrorLog.Error("Error getting current Mesos Master leader: %s", err)
+		logger.Error("Error getting current Mesos Master leader: %s", err)
 		return
 	}
 
@@ -42,8 +61,8 @@ func (m *MesosMasterNotifier) pollLeader(refresh chan string) {
 		m.leaderRegistry.set(host)
 
 		select {
-		case refresh <- "refresh":
-			log.AppLog.Info("Triggering refresh")
+		case refresh <- types.NewRefreshEvent("mesos_master", fmt.Sprintf("leader changed to %s:%d", host.Ip, host.Port)):
+			logger.Info("Triggering refresh")
 		default:
 		}
 	}
@@ -52,7 +71,8 @@ func (m *MesosMasterNotifier) pollLeader(refresh chan string) {
 }
 
 func NewMesosNotifier(c *Config, lr *leaderRegistry) (*MesosMasterNotifier, error) {
-	hc := &http.Client{}
+	// A request must not take longer than the interval between two polls.
+	hc := &http.Client{Timeout: time.Duration(c.PollInterval) * time.Second}
 	masters := strings.Split(c.Masters, ",")
 
 	if len(masters) == 0 {
@@ -1,8 +1,10 @@
 package mesos_master
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -12,10 +14,8 @@ import (
 )
 
 func TestShouldTriggerRefreshWhenMasterChanges(t *testing.T) {
-	refresh := make(chan string, 1)
+	refresh := make(chan types.RefreshEvent, 1)
 	reqCount := 0
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.RequestURI == "/master/state.json" {
@@ -53,15 +53,17 @@ func TestShouldTriggerRefreshWhenMasterChanges(t *testing.T) {
 		lr,
 	)
 
-	go n.Start(refresh, make(chan int), wg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go n.Start(ctx, refresh)
 
 	time.Sleep(3 * time.Second)
 
 	select {
-	case msg := <-refresh:
-		if msg != "refresh" {
-			require.FailNow(t, "Expect message from refresh channel to be of value 'refresh'")
-		}
+	case event := <-refresh:
+		require.Equal(t, "mesos_master", event.Notifier)
+		require.Contains(t, event.Reason, "leader changed to")
 		host := lr.get()
 		require.Equal(t, "10.11.10.10", host.Ip)
 		require.Equal(t, 5050, host.Port)
@@ -69,3 +71,18 @@ func TestShouldTriggerRefreshWhenMasterChanges(t *testing.T) {
 		require.FailNow(t, "Expect to receive message from refresh channel")
 	}
 }
+
+func TestPreloadSetsLeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(state{Leader: "master@10.10.10.10:5050"})
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	lr := &leaderRegistry{mutex: &sync.Mutex{}}
+
+	n, _ := NewMesosNotifier(&Config{Masters: ts.URL, PollInterval: 1}, lr)
+
+	require.Nil(t, n.Preload())
+	require.Equal(t, types.Host{Ip: "10.10.10.10", Port: 5050}, lr.get())
+}
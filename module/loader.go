@@ -0,0 +1,290 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/wndhydrnt/proxym/config"
+	"github.com/wndhydrnt/proxym/log"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/types"
+	fsnotify "gopkg.in/fsnotify.v1"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+var logger = log.Module("module")
+
+// A Loader sets up modules in a Manager using a configuration file and environment variables.
+//
+// It also implements the Notifier interface. It re-applies all settings that can be changed at runtime whenever the
+// configuration file changes or the process receives SIGHUP and triggers a refresh afterwards.
+type Loader struct {
+	configs map[string]Config
+	file    *config.File
+	m       *manager.Manager
+	path    string
+}
+
+// Load reads the configuration file. Does nothing if no configuration file has been set.
+func (l *Loader) Load() error {
+	if l.path == "" {
+		return nil
+	}
+
+	f, err := config.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	l.file = f
+
+	return nil
+}
+
+// ConfigureManager applies the settings of the Manager read from the configuration file and environment variables.
+// Must be called before the Manager is started.
+func (l *Loader) ConfigureManager() error {
+	c := &manager.Config{}
+
+	err := config.Apply(c, l.file.ManagerSettings(), "proxym")
+	if err != nil {
+		return fmt.Errorf("Error reading configuration of manager: %s", err)
+	}
+
+	*l.m.Config = *c
+
+	return nil
+}
+
+// ConfigureLogging applies the settings of logging read from the configuration file and environment variables.
+func (l *Loader) ConfigureLogging() error {
+	c := &log.Config{}
+
+	err := config.Apply(c, l.file.LogSettings(), "proxym_log")
+	if err != nil {
+		return fmt.Errorf("Error reading configuration of logging: %s", err)
+	}
+
+	err = log.Configure(c)
+	if err != nil {
+		return fmt.Errorf("Error configuring logging: %s", err)
+	}
+
+	return nil
+}
+
+// Setup adds the components of a module to the Manager if the module has been enabled.
+func (l *Loader) Setup(name string) error {
+	mod, err := Get(name)
+	if err != nil {
+		return err
+	}
+
+	c, err := mod.LoadConfig(l.file)
+	if err != nil {
+		return err
+	}
+
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	err = mod.validate(c)
+	if err != nil {
+		return err
+	}
+
+	err = mod.Setup(l.m, c)
+	if err != nil {
+		return fmt.Errorf("Error setting up module %s: %s", name, err)
+	}
+
+	l.configs[name] = c
+
+	return nil
+}
+
+// SetupEnabled sets up every registered module that has been enabled.
+func (l *Loader) SetupEnabled() error {
+	for _, name := range Names() {
+		err := l.Setup(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the configuration of every enabled module without setting it up, so no module connects to other
+// systems. It does not stop at the first invalid module. It returns the names of all enabled modules and an error for
+// every invalid module.
+func (l *Loader) Validate() ([]string, []error) {
+	var enabled []string
+	var errs []error
+
+	for _, name := range Names() {
+		mod, _ := Get(name)
+
+		c, err := mod.LoadConfig(l.file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if !c.IsEnabled() {
+			continue
+		}
+
+		enabled = append(enabled, name)
+
+		err = mod.validate(c)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return enabled, errs
+}
+
+// Config returns the configuration of a module read from the configuration file and environment variables.
+func (l *Loader) Config(name string) (Config, error) {
+	mod, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return mod.LoadConfig(l.file)
+}
+
+// Reload reads the configuration file again and applies all settings that can be changed at runtime. Settings of
+// logging are always applied.
+// Nothing is applied if the configuration contains an error.
+func (l *Loader) Reload() error {
+	f, err := config.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	logConfig := &log.Config{}
+	err = config.Apply(logConfig, f.LogSettings(), "proxym_log")
+	if err != nil {
+		return fmt.Errorf("Error reading configuration of logging: %s", err)
+	}
+
+	managerConfig := &manager.Config{}
+	err = config.Apply(managerConfig, f.ManagerSettings(), "proxym")
+	if err != nil {
+		return fmt.Errorf("Error reading configuration of manager: %s", err)
+	}
+
+	moduleConfigs := make(map[string]Config)
+
+	for _, name := range Names() {
+		mod, _ := Get(name)
+
+		c, err := mod.LoadConfig(f)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := l.configs[name]; ok {
+			err := mod.validate(c)
+			if err != nil {
+				return err
+			}
+
+			moduleConfigs[name] = c
+		} else if c.IsEnabled() {
+			logger.Warning("Enabling module %s requires a restart", name)
+		}
+	}
+
+	err = log.Configure(logConfig)
+	if err != nil {
+		return fmt.Errorf("Error configuring logging: %s", err)
+	}
+
+	l.m.Reconfigure(func() {
+		for _, setting := range config.CopyReloadable(l.m.Config, managerConfig) {
+			logger.Warning("Changing setting '%s' of manager requires a restart", setting)
+		}
+
+		for name, c := range moduleConfigs {
+			for _, setting := range config.CopyReloadable(l.configs[name], c) {
+				logger.Warning("Changing setting '%s' of module %s requires a restart", setting, name)
+			}
+		}
+	})
+
+	l.file = f
+
+	return nil
+}
+
+// Start implements the Notifier interface.
+func (l *Loader) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("Unable to watch configuration file '%s': %s", l.path, err)
+		return
+	}
+	defer w.Close()
+
+	// Watch the directory because editors replace the file instead of writing to it.
+	err = w.Add(filepath.Dir(l.path))
+	if err != nil {
+		logger.Error("Unable to watch configuration file '%s': %s", l.path, err)
+		return
+	}
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGHUP)
+	defer signal.Stop(sc)
+
+	for {
+		var reason string
+
+		select {
+		case event := <-w.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(l.path) {
+				continue
+			}
+
+			if event.Op&fsnotify.Create != fsnotify.Create && event.Op&fsnotify.Write != fsnotify.Write {
+				continue
+			}
+
+			reason = event.String()
+		case s := <-sc:
+			reason = s.String()
+		case err := <-w.Errors:
+			logger.Error("Error watching configuration file '%s': %s", l.path, err)
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		err := l.Reload()
+		if err != nil {
+			logger.Error("Not reloading configuration: %s", err)
+			continue
+		}
+
+		logger.Info("Reloaded configuration file '%s'", l.path)
+
+		select {
+		case refresh <- types.NewRefreshEvent("config", reason):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// NewLoader creates a new Loader that reads the configuration file at path. path can be empty in which case only
+// environment variables are used.
+func NewLoader(m *manager.Manager, path string) *Loader {
+	return &Loader{configs: make(map[string]Config), m: m, path: path}
+}
@@ -0,0 +1,200 @@
+package module
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/manager"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type reloadableConfig struct {
+	Enabled  bool
+	Interval int `reload:"true"`
+	Servers  string
+}
+
+func (c *reloadableConfig) IsEnabled() bool {
+	return c.Enabled
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	require.Nil(t, err)
+}
+
+func TestLoaderReload(t *testing.T) {
+	var received *reloadableConfig
+
+	Register(&Module{
+		Name:      "unittest_reload",
+		NewConfig: func() Config { return &reloadableConfig{} },
+		Setup: func(m *manager.Manager, c Config) error {
+			received = c.(*reloadableConfig)
+			return nil
+		},
+	})
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxym.yml")
+	writeConfigFile(t, path, `
+manager:
+  listen_address: "127.0.0.1:0"
+  refresh_quiet_period: 10
+modules:
+  unittest_reload:
+    enabled: true
+    interval: 5
+    servers: "a"
+`)
+
+	m := manager.New()
+	l := NewLoader(m, path)
+
+	require.Nil(t, l.Load())
+	require.Nil(t, l.ConfigureManager())
+	require.Nil(t, l.Setup("unittest_reload"))
+	require.Equal(t, 5, received.Interval)
+	require.Equal(t, 10, m.Config.RefreshQuietPeriod)
+
+	go m.Run()
+	defer m.Quit()
+
+	writeConfigFile(t, path, `
+manager:
+  listen_address: "127.0.0.1:0"
+  refresh_quiet_period: 20
+modules:
+  unittest_reload:
+    enabled: true
+    interval: 15
+    servers: "b"
+`)
+
+	require.Nil(t, l.Reload())
+	// Wait for the Manager to apply the change.
+	m.Reconfigure(func() {})
+
+	require.Equal(t, 15, received.Interval)
+	require.Equal(t, "a", received.Servers)
+	require.Equal(t, 20, m.Config.RefreshQuietPeriod)
+}
+
+func TestLoaderReloadKeepsSettingsOnError(t *testing.T) {
+	var received *reloadableConfig
+
+	Register(&Module{
+		Name:      "unittest_reload_error",
+		NewConfig: func() Config { return &reloadableConfig{} },
+		Validate: func(c Config) error {
+			if c.(*reloadableConfig).Interval < 0 {
+				return errors.New("interval is negative")
+			}
+
+			return nil
+		},
+		Setup: func(m *manager.Manager, c Config) error {
+			received = c.(*reloadableConfig)
+			return nil
+		},
+	})
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxym.yml")
+	writeConfigFile(t, path, "modules:\n  unittest_reload_error:\n    enabled: true\n    interval: 5\n")
+
+	l := NewLoader(manager.New(), path)
+
+	require.Nil(t, l.Load())
+	require.Nil(t, l.Setup("unittest_reload_error"))
+
+	writeConfigFile(t, path, "modules:\n  unittest_reload_error:\n    enabled: true\n    interval: five\n")
+
+	require.NotNil(t, l.Reload())
+	require.Equal(t, 5, received.Interval)
+
+	writeConfigFile(t, path, "modules:\n  unittest_reload_error:\n    enabled: true\n    interval: -1\n")
+
+	require.EqualError(t, l.Reload(), "Invalid configuration of module unittest_reload_error: interval is negative")
+	require.Equal(t, 5, received.Interval)
+}
+
+func TestLoaderConfigureLogging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxym.yml")
+	writeConfigFile(t, path, "log:\n  encoding: xml\n")
+
+	l := NewLoader(manager.New(), path)
+
+	require.Nil(t, l.Load())
+	require.NotNil(t, l.ConfigureLogging())
+
+	writeConfigFile(t, path, "log:\n  levels: marathon=debug\n")
+
+	require.Nil(t, l.Load())
+	require.Nil(t, l.ConfigureLogging())
+}
+
+func TestLoaderValidate(t *testing.T) {
+	setupCalled := false
+	setup := func(m *manager.Manager, c Config) error {
+		setupCalled = true
+		return nil
+	}
+
+	Register(&Module{
+		Name:      "unittest_validate_ok",
+		NewConfig: func() Config { return &reloadableConfig{} },
+		Setup:     setup,
+	})
+
+	Register(&Module{
+		Name:      "unittest_validate_fail",
+		NewConfig: func() Config { return &reloadableConfig{} },
+		Validate: func(c Config) error {
+			if c.(*reloadableConfig).Servers == "" {
+				return errors.New("servers not set")
+			}
+
+			return nil
+		},
+		Setup: setup,
+	})
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxym.yml")
+	writeConfigFile(t, path, `
+modules:
+  unittest_validate_fail:
+    enabled: true
+  unittest_validate_ok:
+    enabled: true
+`)
+
+	l := NewLoader(manager.New(), path)
+	require.Nil(t, l.Load())
+
+	enabled, errs := l.Validate()
+
+	require.Equal(t, []string{"unittest_validate_fail", "unittest_validate_ok"}, enabled)
+	require.Len(t, errs, 1)
+	require.Equal(t, "Invalid configuration of module unittest_validate_fail: servers not set", errs[0].Error())
+	require.False(t, setupCalled)
+
+	require.Equal(t, errs[0], l.Setup("unittest_validate_fail"))
+	require.False(t, setupCalled)
+}
@@ -0,0 +1,127 @@
+// Package module keeps a registry of modules. A module declares its configuration and how to create its Notifiers,
+// ServiceGenerators, Annotators and ConfigGenerators. The main program or an application that embeds proxym decides
+// which modules are set up in which Manager.
+package module
+
+import (
+	"fmt"
+	"github.com/wndhydrnt/proxym/config"
+	"github.com/wndhydrnt/proxym/manager"
+	"sort"
+	"sync"
+)
+
+// Config is implemented by the configuration struct of every module.
+// Fields tagged with `reload:"true"` are updated when the configuration file changes. Only tag fields that are read
+// during a refresh, i.e. by ServiceGenerators, Annotators or ConfigGenerators.
+type Config interface {
+	// IsEnabled reports whether the module has been enabled.
+	IsEnabled() bool
+}
+
+// A Module describes how to configure and set up an integration of proxym.
+type Module struct {
+	// Name of the module, e.g. "marathon".
+	Name string
+	// Prefix of the environment variables of the module. Defaults to "proxym_<Name>".
+	EnvPrefix string
+	// NewConfig returns a pointer to an empty configuration struct of the module.
+	NewConfig func() Config
+	// Validate checks the configuration of the module. Optional. It must not connect to other systems, create files
+	// or start goroutines because "proxym validate" calls it instead of Setup.
+	Validate func(c Config) error
+	// Setup creates the components of the module and adds them to a Manager. It is only called if Validate succeeds.
+	Setup func(m *manager.Manager, c Config) error
+}
+
+// Checks c if the module declares a Validate function.
+func (mod *Module) validate(c Config) error {
+	if mod.Validate == nil {
+		return nil
+	}
+
+	err := mod.Validate(c)
+	if err != nil {
+		return fmt.Errorf("Invalid configuration of module %s: %s", mod.Name, err)
+	}
+
+	return nil
+}
+
+// LoadConfig reads the configuration of the module from a configuration file and from environment variables.
+// f can be nil.
+func (mod *Module) LoadConfig(f *config.File) (Config, error) {
+	c := mod.NewConfig()
+
+	err := config.Apply(c, f.Module(mod.Name), mod.envPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("Error reading configuration of module %s: %s", mod.Name, err)
+	}
+
+	return c, nil
+}
+
+func (mod *Module) envPrefix() string {
+	if mod.EnvPrefix == "" {
+		return "proxym_" + mod.Name
+	}
+
+	return mod.EnvPrefix
+}
+
+var (
+	modules = make(map[string]*Module)
+	mutex   = &sync.Mutex{}
+)
+
+// Register makes a module available by its name. It panics if a module with the same name has already been
+// registered.
+func Register(mod *Module) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := modules[mod.Name]; ok {
+		panic(fmt.Sprintf("Module %s registered twice", mod.Name))
+	}
+
+	modules[mod.Name] = mod
+}
+
+// Get returns the module registered under name.
+func Get(name string) (*Module, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	mod, ok := modules[name]
+	if !ok {
+		return nil, fmt.Errorf("Module %s does not exist", name)
+	}
+
+	return mod, nil
+}
+
+// Names returns the names of all registered modules in alphabetical order.
+func Names() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var names []string
+	for name := range modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Setup reads the configuration of a module from environment variables and adds its components to m.
+// Nothing is added if the module has not been enabled.
+func Setup(m *manager.Manager, name string) error {
+	return NewLoader(m, "").Setup(name)
+}
+
+// SetupEnabled sets up every registered module that has been enabled via environment variables.
+func SetupEnabled(m *manager.Manager) error {
+	return NewLoader(m, "").SetupEnabled()
+}
@@ -0,0 +1,88 @@
+package module
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/manager"
+	"os"
+	"testing"
+)
+
+type testConfig struct {
+	Enabled bool
+	Value   string
+}
+
+func (c *testConfig) IsEnabled() bool {
+	return c.Enabled
+}
+
+func TestSetupReadsConfigFromEnvironment(t *testing.T) {
+	var received *testConfig
+
+	Register(&Module{
+		Name:      "unittest_env",
+		NewConfig: func() Config { return &testConfig{} },
+		Setup: func(m *manager.Manager, c Config) error {
+			received = c.(*testConfig)
+			return nil
+		},
+	})
+
+	os.Setenv("PROXYM_UNITTEST_ENV_ENABLED", "1")
+	os.Setenv("PROXYM_UNITTEST_ENV_VALUE", "unit test")
+	defer os.Unsetenv("PROXYM_UNITTEST_ENV_ENABLED")
+	defer os.Unsetenv("PROXYM_UNITTEST_ENV_VALUE")
+
+	err := Setup(manager.New(), "unittest_env")
+
+	require.Nil(t, err)
+	require.Equal(t, "unit test", received.Value)
+}
+
+func TestSetupSkipsDisabledModule(t *testing.T) {
+	called := false
+
+	Register(&Module{
+		Name:      "unittest_disabled",
+		EnvPrefix: "proxym_unittest_custom",
+		NewConfig: func() Config { return &testConfig{} },
+		Setup: func(m *manager.Manager, c Config) error {
+			called = true
+			return nil
+		},
+	})
+
+	err := Setup(manager.New(), "unittest_disabled")
+
+	require.Nil(t, err)
+	require.False(t, called)
+}
+
+func TestSetupReturnsErrorOfModule(t *testing.T) {
+	Register(&Module{
+		Name:      "unittest_error",
+		NewConfig: func() Config { return &testConfig{Enabled: true} },
+		Setup: func(m *manager.Manager, c Config) error {
+			return errors.New("misconfigured")
+		},
+	})
+
+	err := Setup(manager.New(), "unittest_error")
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "unittest_error")
+	require.Contains(t, err.Error(), "misconfigured")
+}
+
+func TestSetupUnknownModule(t *testing.T) {
+	require.NotNil(t, Setup(manager.New(), "unknown"))
+}
+
+func TestRegisterPanicsOnDuplicateName(t *testing.T) {
+	mod := &Module{Name: "unittest_duplicate", NewConfig: func() Config { return &testConfig{} }}
+
+	Register(mod)
+
+	require.Panics(t, func() { Register(mod) })
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/wndhydrnt/proxym/types"
+)
+
+// ServiceGenerator implements the ContextServiceGenerator interface. It parses the output of a command as a JSON
+// array of services. Services without a Source get the Source "Plugin".
+type ServiceGenerator struct {
+	c       *Config
+	metrics *metrics
+}
+
+// GenerateContext implements the ContextServiceGenerator interface.
+func (sg *ServiceGenerator) GenerateContext(ctx context.Context) ([]*types.Service, error) {
+	out, err := run(ctx, "service_generator", sg.c.ServiceGeneratorCommand, nil, sg.c.Timeout, sg.metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []*types.Service
+
+	err = json.Unmarshal(out, &services)
+	if err != nil {
+		sg.metrics.failures.WithLabelValues("service_generator").Inc()
+		return nil, fmt.Errorf("Unable to parse output of service generator command: %s", err)
+	}
+
+	for _, s := range services {
+		if s.Source == "" {
+			s.Source = "Plugin"
+		}
+	}
+
+	return services, nil
+}
+
+// ConfigGenerator implements the ConfigGenerator interface. It writes the services as a JSON array to the stdin of a
+// command. A non-zero exit code fails the refresh.
+type ConfigGenerator struct {
+	c       *Config
+	metrics *metrics
+}
+
+// Generate implements the ConfigGenerator interface.
+func (cg *ConfigGenerator) Generate(services []*types.Service) error {
+	if services == nil {
+		services = []*types.Service{}
+	}
+
+	data, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+
+	_, err = run(context.Background(), "config_generator", cg.c.ConfigGeneratorCommand, bytes.NewReader(data), cg.c.Timeout, cg.metrics)
+
+	return err
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wndhydrnt/proxym/types"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Notifier implements the Notifier interface. It runs a long-running command and triggers a refresh whenever the
+// command prints a line to stdout. The line is used as the reason of the refresh. The command is restarted if it
+// exits or stays silent for longer than NotifierIdleTimeout.
+type Notifier struct {
+	c       *Config
+	metrics *metrics
+}
+
+// Start implements the Notifier interface.
+func (n *Notifier) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	for {
+		err := n.watch(ctx, refresh)
+		if ctx.Err() != nil {
+			return
+		}
+
+		n.metrics.failures.WithLabelValues("notifier").Inc()
+
+		delay := time.Duration(n.c.NotifierRestartDelay) * time.Second
+		logger.Error("%s. Restarting in %s", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Runs the command until it exits, times out or ctx is done.
+func (n *Notifier) watch(ctx context.Context, refresh chan types.RefreshEvent) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.Command("/bin/bash", "-c", n.c.NotifierCommand)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	wait, err := startGroup(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("Unable to start notifier command: %s", err)
+	}
+
+	go logLines("notifier", stderr)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err = n.forward(ctx, lines, refresh)
+	cancel()
+
+	// Wait releases the resources of the command. Its error is less descriptive than the one returned by forward.
+	wait()
+
+	return err
+}
+
+// Triggers a refresh for every line until lines is closed, no line has been received within the idle timeout or ctx
+// is done.
+func (n *Notifier) forward(ctx context.Context, lines chan string, refresh chan types.RefreshEvent) error {
+	var idle <-chan time.Time
+
+	for {
+		if n.c.NotifierIdleTimeout > 0 {
+			idle = time.After(time.Duration(n.c.NotifierIdleTimeout) * time.Second)
+		}
+
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return errors.New("Notifier command exited")
+			}
+
+			select {
+			case refresh <- types.NewRefreshEvent("plugin", strings.TrimSpace(line)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-idle:
+			return fmt.Errorf("Notifier command printed nothing for %d seconds", n.c.NotifierIdleTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
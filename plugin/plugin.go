@@ -0,0 +1,186 @@
+// Package plugin integrates external programs as ServiceGenerator, ConfigGenerator and Notifier.
+//
+// Commands are executed with "/bin/bash -c". Services are exchanged as a JSON array of types.Service. Everything a
+// command writes to stderr is logged.
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wndhydrnt/proxym/log"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var logger = log.Module("plugin")
+
+type Config struct {
+	// Command that receives the services as a JSON array on stdin.
+	ConfigGeneratorCommand string `envconfig:"config_generator_command" reload:"true"`
+	Enabled                bool
+	// Long-running command that triggers a refresh whenever it prints a line to stdout.
+	NotifierCommand string `envconfig:"notifier_command"`
+	// Time (in seconds) without a line from the Notifier command after which the command is restarted.
+	// 0 disables the timeout.
+	NotifierIdleTimeout int `envconfig:"notifier_idle_timeout"`
+	// Time (in seconds) to wait before the Notifier command is restarted after it exited.
+	NotifierRestartDelay int `envconfig:"notifier_restart_delay" default:"5"`
+	// Command that prints services as a JSON array to stdout.
+	ServiceGeneratorCommand string `envconfig:"service_generator_command" reload:"true"`
+	// Time (in seconds) the commands of the ServiceGenerator and the ConfigGenerator are allowed to take.
+	// 0 means no timeout.
+	Timeout int `default:"30" reload:"true"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
+type metrics struct {
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "proxym",
+		Subsystem: "plugin",
+		Name:      "command_duration_seconds",
+		Help:      "Time it took to execute the command of the ServiceGenerator or the ConfigGenerator",
+	}, []string{"component"})
+	duration = prometheus.MustRegisterOrGet(duration).(*prometheus.HistogramVec)
+
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "plugin",
+		Name:      "command_failures_count",
+		Help:      "Number of failed executions of a command",
+	}, []string{"component"})
+	failures = prometheus.MustRegisterOrGet(failures).(*prometheus.CounterVec)
+
+	return &metrics{duration: duration, failures: failures}
+}
+
+// Executes command and returns what it has written to stdout. stdin can be nil.
+// The command is killed once ctx is done or timeout (in seconds) has passed.
+func run(ctx context.Context, component, command string, stdin io.Reader, timeout int, m *metrics) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.Command("/bin/bash", "-c", command)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	wait, err := startGroup(ctx, cmd)
+	if err == nil {
+		err = wait()
+	}
+	m.duration.WithLabelValues(component).Observe(time.Since(start).Seconds())
+
+	stderrOutput := strings.TrimSpace(stderr.String())
+	logLines(component, &stderr)
+
+	if err != nil {
+		m.failures.WithLabelValues(component).Inc()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("Command of %s timed out after %d seconds", component, timeout)
+		}
+
+		return nil, fmt.Errorf("Command of %s failed: %s - Stderr: %s", component, err, stderrOutput)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Starts cmd in its own process group and kills the whole group once ctx is done. Killing only bash would leave the
+// processes it started running, and they would keep stdout and stderr open. The returned function waits for cmd to
+// exit.
+func startGroup(ctx context.Context, cmd *exec.Cmd) (func() error, error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	return func() error {
+		err := cmd.Wait()
+		close(done)
+		return err
+	}, nil
+}
+
+// Logs every line read from r.
+func logLines(component string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logger.Info("Command of %s: %s", component, scanner.Text())
+	}
+}
+
+func validate(mc module.Config) error {
+	c := mc.(*Config)
+
+	if c.ConfigGeneratorCommand == "" && c.NotifierCommand == "" && c.ServiceGeneratorCommand == "" {
+		return errors.New("None of PROXYM_PLUGIN_CONFIG_GENERATOR_COMMAND, PROXYM_PLUGIN_NOTIFIER_COMMAND and PROXYM_PLUGIN_SERVICE_GENERATOR_COMMAND set")
+	}
+
+	return nil
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
+
+	met := newMetrics()
+
+	if c.ServiceGeneratorCommand != "" {
+		m.AddContextServiceGenerator(&ServiceGenerator{c: c, metrics: met})
+	}
+
+	if c.ConfigGeneratorCommand != "" {
+		m.AddConfigGenerator(&ConfigGenerator{c: c, metrics: met})
+	}
+
+	if c.NotifierCommand != "" {
+		m.AddNotifier(&Notifier{c: c, metrics: met})
+	}
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "plugin",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
+}
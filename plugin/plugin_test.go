@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServiceGeneratorParsesOutputOfCommand(t *testing.T) {
+	sg := &ServiceGenerator{
+		c: &Config{
+			ServiceGeneratorCommand: `echo '[{"id": "redis", "transportProtocol": "tcp", "port": 6379, "hosts": [{"ip": "10.10.10.10", "port": 31001}]}]'`,
+		},
+		metrics: newMetrics(),
+	}
+
+	services, err := sg.GenerateContext(context.Background())
+
+	require.Nil(t, err)
+	require.Len(t, services, 1)
+	require.Equal(t, "redis", services[0].Id)
+	require.Equal(t, "tcp", services[0].TransportProtocol)
+	require.Equal(t, []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}, services[0].Hosts)
+	require.Equal(t, "Plugin", services[0].Source)
+}
+
+func TestServiceGeneratorFails(t *testing.T) {
+	sg := &ServiceGenerator{c: &Config{ServiceGeneratorCommand: "echo 'no services' >&2; exit 1"}, metrics: newMetrics()}
+
+	_, err := sg.GenerateContext(context.Background())
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "no services")
+
+	sg.c.ServiceGeneratorCommand = "echo 'invalid'"
+
+	_, err = sg.GenerateContext(context.Background())
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Unable to parse output")
+}
+
+func TestServiceGeneratorTimesOut(t *testing.T) {
+	sg := &ServiceGenerator{c: &Config{ServiceGeneratorCommand: "sleep 5 | cat; echo '[]'", Timeout: 1}, metrics: newMetrics()}
+
+	start := time.Now()
+	_, err := sg.GenerateContext(context.Background())
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "timed out")
+	require.True(t, time.Since(start) < 3*time.Second)
+}
+
+func TestConfigGeneratorPassesServicesToCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "services.json")
+
+	cg := &ConfigGenerator{c: &Config{ConfigGeneratorCommand: "cat > " + out}, metrics: newMetrics()}
+
+	err = cg.Generate([]*types.Service{&types.Service{Id: "redis"}})
+	require.Nil(t, err)
+
+	data, _ := ioutil.ReadFile(out)
+	require.Contains(t, string(data), `"Id":"redis"`)
+
+	cg.c.ConfigGeneratorCommand = "exit 2"
+
+	require.NotNil(t, cg.Generate(nil))
+}
+
+func TestNotifierTriggersRefreshPerLine(t *testing.T) {
+	n := &Notifier{
+		c:       &Config{NotifierCommand: "echo first; echo second; sleep 10", NotifierRestartDelay: 1},
+		metrics: newMetrics(),
+	}
+	refresh := make(chan types.RefreshEvent, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		n.Start(ctx, refresh)
+		close(stopped)
+	}()
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case event := <-refresh:
+			require.Equal(t, "plugin", event.Notifier)
+			require.Equal(t, expected, event.Reason)
+		case <-time.After(2 * time.Second):
+			t.Fatal("No refresh triggered")
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Notifier did not stop")
+	}
+}
+
+func TestNotifierRestartsIdleCommand(t *testing.T) {
+	n := &Notifier{
+		c:       &Config{NotifierCommand: "sleep 10", NotifierIdleTimeout: 1},
+		metrics: newMetrics(),
+	}
+
+	start := time.Now()
+	err := n.watch(context.Background(), make(chan types.RefreshEvent))
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "printed nothing")
+	require.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,228 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pinnedFile = "pinned"
+
+// A Revision is a configuration file that has been written and reloaded.
+type Revision struct {
+	// Content of the configuration file. Not included in lists of revisions.
+	Config string `json:"config,omitempty"`
+	// Unified diff against the configuration file the revision replaced. Not included in lists of revisions.
+	Diff string `json:"diff,omitempty"`
+	// The refresh that caused the revision. Rollbacks are caused by an event of the Notifier "proxy".
+	Event types.RefreshEvent `json:"event"`
+	Id    int                `json:"id"`
+	// Set if automatic updates are suspended and the revision stays in place.
+	Pinned  bool      `json:"pinned"`
+	Written time.Time `json:"written"`
+}
+
+type byId []*Revision
+
+func (b byId) Len() int           { return len(b) }
+func (b byId) Less(i, j int) bool { return b[i].Id < b[j].Id }
+func (b byId) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Stores revisions as JSON files in a directory. Only the most recent revisions are kept. The pinned revision is
+// stored in the file "pinned" so it survives a restart.
+type history struct {
+	dir   string
+	mutex *sync.Mutex
+	// Id of the pinned revision. 0 if no revision is pinned.
+	pinned int
+	// All revisions without Config and Diff, the oldest first.
+	revisions []*Revision
+}
+
+// Reads the revisions stored in dir. Creates dir if it does not exist.
+func newHistory(dir string) (*history, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create history directory '%s': %s", dir, err)
+	}
+
+	hi := &history{dir: dir, mutex: &sync.Mutex{}}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		r, err := readRevision(path)
+		if err != nil {
+			return nil, err
+		}
+
+		hi.revisions = append(hi.revisions, summary(r))
+	}
+
+	sort.Sort(byId(hi.revisions))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, pinnedFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		hi.pinned, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid content of '%s': %s", filepath.Join(dir, pinnedFile), err)
+		}
+	}
+
+	return hi, nil
+}
+
+func readRevision(path string) (*Revision, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Revision{}
+
+	err = json.Unmarshal(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse revision '%s': %s", path, err)
+	}
+
+	return r, nil
+}
+
+// Returns a copy of r without Config and Diff.
+func summary(r *Revision) *Revision {
+	return &Revision{Event: r.Event, Id: r.Id, Written: r.Written}
+}
+
+func (hi *history) path(id int) string {
+	return filepath.Join(hi.dir, strconv.Itoa(id)+".json")
+}
+
+// Stores a new revision and removes the oldest revisions if more than size revisions are stored. The pinned revision
+// is never removed.
+func (hi *history) add(config, diff string, event types.RefreshEvent, size int) (*Revision, error) {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	id := 1
+	if len(hi.revisions) > 0 {
+		id = hi.revisions[len(hi.revisions)-1].Id + 1
+	}
+
+	r := &Revision{Config: config, Diff: diff, Event: event, Id: id, Written: time.Now()}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(hi.path(id), data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to write revision %d: %s", id, err)
+	}
+
+	hi.revisions = append(hi.revisions, summary(r))
+
+	if size < 1 {
+		size = 1
+	}
+
+	var kept []*Revision
+	for i, old := range hi.revisions {
+		if i >= len(hi.revisions)-size || old.Id == hi.pinned {
+			kept = append(kept, old)
+			continue
+		}
+
+		err := os.Remove(hi.path(old.Id))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error("Unable to remove revision %d: %s", old.Id, err)
+		}
+	}
+
+	hi.revisions = kept
+
+	return r, nil
+}
+
+// Returns a revision including Config and Diff. The error satisfies os.IsNotExist if the revision does not exist.
+func (hi *history) get(id int) (*Revision, error) {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	r, err := readRevision(hi.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Pinned = r.Id == hi.pinned
+
+	return r, nil
+}
+
+// Returns all revisions without Config and Diff, the most recent one first.
+func (hi *history) list() []*Revision {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	revisions := make([]*Revision, len(hi.revisions))
+
+	for i, r := range hi.revisions {
+		s := summary(r)
+		s.Pinned = s.Id == hi.pinned
+
+		revisions[len(hi.revisions)-1-i] = s
+	}
+
+	return revisions
+}
+
+// Returns the id of the pinned revision or 0 if no revision is pinned.
+func (hi *history) pinnedRevision() int {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	return hi.pinned
+}
+
+func (hi *history) pin(id int) error {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	err := ioutil.WriteFile(filepath.Join(hi.dir, pinnedFile), []byte(strconv.Itoa(id)+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to pin revision %d: %s", id, err)
+	}
+
+	hi.pinned = id
+
+	return nil
+}
+
+func (hi *history) unpin() error {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+
+	err := os.Remove(filepath.Join(hi.dir, pinnedFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to unpin revision %d: %s", hi.pinned, err)
+	}
+
+	hi.pinned = 0
+
+	return nil
+}
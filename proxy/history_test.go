@@ -0,0 +1,70 @@
+package haproxy
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryKeepsMostRecentRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	hi, err := newHistory(dir)
+	require.Nil(t, err)
+
+	event := types.NewRefreshEvent("marathon", "status_update_event")
+
+	for _, config := range []string{"one", "two", "three"} {
+		_, err := hi.add(config, "", event, 2)
+		require.Nil(t, err)
+	}
+
+	revisions := hi.list()
+	require.Len(t, revisions, 2)
+	require.Equal(t, 3, revisions[0].Id)
+	require.Equal(t, 2, revisions[1].Id)
+	require.Equal(t, "marathon", revisions[0].Event.Notifier)
+	require.Empty(t, revisions[0].Config)
+
+	_, err = hi.get(1)
+	require.True(t, os.IsNotExist(err))
+
+	r, err := hi.get(3)
+	require.Nil(t, err)
+	require.Equal(t, "three", r.Config)
+}
+
+func TestHistoryKeepsPinnedRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	hi, err := newHistory(dir)
+	require.Nil(t, err)
+
+	hi.add("one", "", types.RefreshEvent{}, 1)
+	require.Nil(t, hi.pin(1))
+
+	hi.add("two", "", types.RefreshEvent{}, 1)
+
+	revisions := hi.list()
+	require.Len(t, revisions, 2)
+	require.True(t, revisions[1].Pinned)
+
+	// A restart reads the revisions and the pin from disk.
+	hi, err = newHistory(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, hi.pinnedRevision())
+	require.Len(t, hi.list(), 2)
+
+	require.Nil(t, hi.unpin())
+	require.Equal(t, 0, hi.pinnedRevision())
+
+	_, err = os.Stat(filepath.Join(dir, pinnedFile))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,108 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Error marshalling response: '%s'", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Executes f between two refreshes so it does not interfere with Generate.
+func (h *HAProxyGenerator) betweenRefreshes(ctx context.Context, f func() error) error {
+	if h.reconfigure == nil {
+		return f()
+	}
+
+	result := make(chan error, 1)
+
+	h.reconfigure(func() { result <- f() })
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *HAProxyGenerator) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.history.list())
+}
+
+func (h *HAProxyGenerator) getRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	revision, err := h.history.get(id)
+	if os.IsNotExist(err) {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		logger.Error("Error reading revision %d: %s", id, err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, revision)
+}
+
+// Writes a revision, checks and reloads it and suspends automatic updates. Responds with the pinned revision.
+func (h *HAProxyGenerator) pinHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	var pinned *Revision
+
+	err = h.betweenRefreshes(r.Context(), func() error {
+		var err error
+		pinned, err = h.pin(id)
+		return err
+	})
+
+	if os.IsNotExist(err) {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		logger.Error("Unable to pin revision %d: %s", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pinned)
+}
+
+// Resumes automatic updates.
+func (h *HAProxyGenerator) unpinHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.betweenRefreshes(r.Context(), h.unpin)
+	if err != nil {
+		logger.Error("%s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,29 +2,72 @@ package haproxy
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/hugo/tpl"
 	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
+	"github.com/wndhydrnt/proxym/utils"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
+var logger = log.Module("proxy")
+
+// Environment variable that contains the path of the candidate file while the check command is executed.
+const candidateEnv = "PROXYM_PROXY_CANDIDATE_FILE"
+
+// Replaced with the path of the candidate file in the check command.
+const candidatePlaceholder = "{{candidate}}"
+
 type Config struct {
-	CheckCommand   string `envconfig:"check_command"`
-	ConfigFilePath string `envconfig:"config_file_path"`
+	// Command that checks a candidate configuration file. Every occurrence of "{{candidate}}" is replaced with the path
+	// of the candidate, which is also passed in the environment variable PROXYM_PROXY_CANDIDATE_FILE.
+	CheckCommand   string `envconfig:"check_command" reload:"true"`
+	ConfigFilePath string `envconfig:"config_file_path" reload:"true"`
 	Enabled        bool
-	ReloadCommand  string `envconfig:"reload_command"`
-	TemplatePath   string `envconfig:"template_path"`
+	// Directory that stores the most recent configuration files. Empty disables the history.
+	HistoryDir string `envconfig:"history_dir"`
+	// Number of configuration files to keep in HistoryDir.
+	HistorySize   int    `envconfig:"history_size" default:"20" reload:"true"`
+	ReloadCommand string `envconfig:"reload_command" reload:"true"`
+	// Maximum number of reloads within one hour. 0 means no limit.
+	ReloadMaxPerHour int `envconfig:"reload_max_per_hour" reload:"true"`
+	// Minimum time (in seconds) between two reloads. 0 means no limit.
+	ReloadMinInterval int    `envconfig:"reload_min_interval" reload:"true"`
+	TemplatePath      string `envconfig:"template_path" reload:"true"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
 }
 
 type HAProxyGenerator struct {
-	c *Config
+	c               *Config
+	commandFailures *prometheus.CounterVec
+	// Returns the event that triggered the current refresh.
+	event       func() types.RefreshEvent
+	heldReloads *prometheus.CounterVec
+	// Nil if HistoryDir is not set.
+	history    *history
+	lastReload time.Time
+	// Executes a function between two refreshes.
+	reconfigure func(func())
+	// Times of the reloads within the last hour.
+	reloads []time.Time
+	// Time at which a refresh to apply a held reload will be triggered.
+	scheduled time.Time
+	// Receives the reason of a refresh to trigger.
+	wakeup chan string
 }
 
 // Creates a new HAproxy config file and reloads HAProxy
@@ -37,29 +80,107 @@ func (h *HAProxyGenerator) Generate(services []*types.Service) error {
 		return nil
 	}
 
-	f, err := os.Create(h.c.ConfigFilePath)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Unable to open config file for reading '%s': %s", h.c.ConfigFilePath, err))
+	if h.history != nil {
+		if id := h.history.pinnedRevision(); id > 0 {
+			logger.Info("Not applying changes because revision %d of proxy configuration is pinned", id)
+			return nil
+		}
+	}
+
+	now := time.Now()
+	if delay, reason := h.reloadDelay(now); delay > 0 {
+		h.hold(now, delay, reason)
+		return nil
 	}
-	defer f.Close()
 
-	_, err = f.WriteString(newConfig)
+	err := h.apply(newConfig, now)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Unable to write config file '%s': %s", h.c.ConfigFilePath, err))
+		return err
 	}
 
-	if h.c.CheckCommand != "" {
-		cmd := exec.Command("/bin/bash", "-c", h.c.CheckCommand)
+	var event types.RefreshEvent
+	if h.event != nil {
+		event = h.event()
+	}
+
+	_, err = h.record(currentConfig, newConfig, event)
+
+	return err
+}
 
-		var cmdErr bytes.Buffer
-		cmd.Stderr = &cmdErr
+// Writes newConfig to a candidate file next to the configuration file and checks it. The configuration file is only
+// replaced if the check passes. If the reload fails, the previous configuration file is restored and reloaded.
+func (h *HAProxyGenerator) apply(newConfig string, now time.Time) error {
+	previousConfig, previousErr := readExistingFile(h.c.ConfigFilePath)
 
-		err := cmd.Run()
+	candidate, err := writeCandidate(h.c.ConfigFilePath, newConfig)
+	if err != nil {
+		return err
+	}
+	// Does nothing once the candidate has been renamed.
+	defer os.Remove(candidate)
+
+	if h.c.CheckCommand != "" {
+		stderr, err := runCommand(checkCommand(h.c, candidate), candidateEnv+"="+candidate)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Check of proxy configuration file failed: %s", cmdErr.String()))
+			h.commandFailures.WithLabelValues("check").Inc()
+			return errors.New(fmt.Sprintf("Check of proxy configuration file failed: %s", stderr))
 		}
 	}
 
+	err = os.Rename(candidate, h.c.ConfigFilePath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to write config file '%s': %s", h.c.ConfigFilePath, err))
+	}
+
+	logger.Info("Reloading proxy configuration")
+
+	h.lastReload = now
+	h.reloads = append(h.reloads, now)
+
+	stderr, err := h.reload()
+	if err == nil {
+		return nil
+	}
+
+	h.commandFailures.WithLabelValues("reload").Inc()
+	reloadErr := fmt.Sprintf("Failed to reload proxy configuration -  Stderr of reload command: %s", stderr)
+
+	// There is nothing to restore if no configuration file existed before.
+	if previousErr != nil {
+		return errors.New(reloadErr)
+	}
+
+	return h.restore(previousConfig, reloadErr)
+}
+
+// Writes the previous configuration file back and reloads the proxy after the reload of a new configuration file
+// failed.
+func (h *HAProxyGenerator) restore(previousConfig, reloadErr string) error {
+	logger.Error("%s. Restoring previous configuration file '%s'", reloadErr, h.c.ConfigFilePath)
+
+	candidate, err := writeCandidate(h.c.ConfigFilePath, previousConfig)
+	if err != nil {
+		return fmt.Errorf("%s - unable to restore previous configuration: %s", reloadErr, err)
+	}
+	defer os.Remove(candidate)
+
+	err = os.Rename(candidate, h.c.ConfigFilePath)
+	if err != nil {
+		return fmt.Errorf("%s - unable to restore previous configuration: %s", reloadErr, err)
+	}
+
+	stderr, err := h.reload()
+	if err != nil {
+		h.commandFailures.WithLabelValues("reload").Inc()
+		return fmt.Errorf("%s - reloading the restored previous configuration failed too: %s", reloadErr, stderr)
+	}
+
+	return fmt.Errorf("%s - restored previous configuration", reloadErr)
+}
+
+// Executes the reload command and returns what it has written to stderr.
+func (h *HAProxyGenerator) reload() (string, error) {
 	var reloadCommand string
 
 	if strings.Contains(h.c.ReloadCommand, "%%s") {
@@ -68,41 +189,243 @@ func (h *HAProxyGenerator) Generate(services []*types.Service) error {
 		reloadCommand = h.c.ReloadCommand
 	}
 
-	cmd := exec.Command("/bin/bash", "-c", reloadCommand)
-	var cmdErr bytes.Buffer
-	cmd.Stderr = &cmdErr
+	return runCommand(reloadCommand)
+}
+
+// Adds newConfig to the history. Does nothing if the history is disabled.
+func (h *HAProxyGenerator) record(currentConfig, newConfig string, event types.RefreshEvent) (*Revision, error) {
+	if h.history == nil {
+		return nil, nil
+	}
+
+	diff := utils.UnifiedDiff(h.c.ConfigFilePath, h.c.ConfigFilePath, currentConfig, newConfig)
+
+	return h.history.add(newConfig, diff, event, h.c.HistorySize)
+}
+
+// Writes and reloads a revision from the history and suspends automatic updates until unpin is called. Rolling back to
+// an earlier revision adds a new revision to the history, which is pinned instead.
+func (h *HAProxyGenerator) pin(id int) (*Revision, error) {
+	r, err := h.history.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentConfig, _ := readExistingFile(h.c.ConfigFilePath)
+
+	if r.Config != currentConfig {
+		logger.Info("Rolling back proxy configuration to revision %d", id)
+
+		err := h.apply(r.Config, time.Now())
+		if err != nil {
+			return nil, err
+		}
 
-	log.AppLog.Info("Reloading proxy configuration")
+		r, err = h.record(currentConfig, r.Config, types.NewRefreshEvent("proxy", fmt.Sprintf("rollback to revision %d", id)))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err = cmd.Run()
+	err = h.history.pin(r.Id)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to reload proxy configuration -  Stderr of reload command: %s", cmdErr.String()))
+		return nil, err
 	}
+
+	logger.Info("Pinned revision %d of proxy configuration", r.Id)
+
+	r.Pinned = true
+
+	return r, nil
+}
+
+// Resumes automatic updates and triggers a refresh to apply the current services.
+func (h *HAProxyGenerator) unpin() error {
+	err := h.history.unpin()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Unpinned proxy configuration")
+
+	select {
+	case h.wakeup <- "configuration unpinned":
+	default:
+	}
+
 	return nil
 }
 
+// Returns how long a reload at now has to be held to stay within ReloadMinInterval and ReloadMaxPerHour, and which of
+// the two limits requires the delay.
+func (h *HAProxyGenerator) reloadDelay(now time.Time) (time.Duration, string) {
+	var recent []time.Time
+	for _, r := range h.reloads {
+		if now.Sub(r) < time.Hour {
+			recent = append(recent, r)
+		}
+	}
+
+	h.reloads = recent
+
+	var delay time.Duration
+	var reason string
+
+	if h.c.ReloadMinInterval > 0 && !h.lastReload.IsZero() {
+		next := h.lastReload.Add(time.Duration(h.c.ReloadMinInterval) * time.Second)
+		if next.After(now) {
+			delay = next.Sub(now)
+			reason = "min_interval"
+		}
+	}
+
+	if h.c.ReloadMaxPerHour > 0 && len(h.reloads) >= h.c.ReloadMaxPerHour {
+		next := h.reloads[len(h.reloads)-h.c.ReloadMaxPerHour].Add(time.Hour)
+		if d := next.Sub(now); d > delay {
+			delay = d
+			reason = "max_per_hour"
+		}
+	}
+
+	return delay, reason
+}
+
+// Holds a reload and makes sure a refresh is triggered once the reload is due.
+func (h *HAProxyGenerator) hold(now time.Time, delay time.Duration, reason string) {
+	h.heldReloads.WithLabelValues(reason).Inc()
+
+	if reason == "max_per_hour" {
+		logger.Error("Proxy has been reloaded %d times within the last hour, which is the maximum. Holding reload for %s",
+			len(h.reloads), delay)
+	} else {
+		logger.Info("Holding reload of proxy configuration for %s", delay)
+	}
+
+	// A refresh that is already scheduled calls Generate again, which holds the reload again if it is still not due.
+	if h.scheduled.After(now) {
+		return
+	}
+
+	h.scheduled = now.Add(delay)
+
+	time.AfterFunc(delay, func() {
+		select {
+		case h.wakeup <- "held reload is due":
+		default:
+		}
+	})
+}
+
+// Start implements the Notifier interface. It triggers a refresh whenever a held reload is due or the configuration
+// has been unpinned.
+func (h *HAProxyGenerator) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	for {
+		select {
+		case reason := <-h.wakeup:
+			select {
+			case refresh <- types.NewRefreshEvent("proxy", reason):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DryRun implements the DryRunConfigGenerator interface. It returns a unified diff between the current configuration
+// file and the configuration that would be written. Neither the check command nor the reload command are executed.
+func (h *HAProxyGenerator) DryRun(services []*types.Service, changes *types.ChangeSet) (string, error) {
+	currentConfig, _ := readExistingFile(h.c.ConfigFilePath)
+	newConfig := h.config(services)
+
+	return utils.UnifiedDiff(h.c.ConfigFilePath, h.c.ConfigFilePath+" (dry run)", currentConfig, newConfig), nil
+}
+
 func (h *HAProxyGenerator) config(services []*types.Service) string {
-	globalConfig, err := readExistingFile(h.c.TemplatePath)
+	config, err := h.Render(services)
 	if err != nil {
-		log.ErrorLog.Error("Unable to read config template. Stopping proxy config generator: %s", err)
+		logger.Error("%s", err)
 		return ""
 	}
 
+	return config
+}
+
+// Render executes the template with services and returns the configuration file without writing it.
+func (h *HAProxyGenerator) Render(services []*types.Service) (string, error) {
+	globalConfig, err := readExistingFile(h.c.TemplatePath)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read config template. Stopping proxy config generator: %s", err)
+	}
+
 	var out bytes.Buffer
 
 	tpl, err := tpl.New().New("proxy").Parse(globalConfig)
 	if err != nil {
-		log.ErrorLog.Error("%s", err)
-		return ""
+		return "", err
 	}
 
 	err = tpl.Execute(&out, services)
 	if err != nil {
-		log.ErrorLog.Error("%s", err)
-		return ""
+		return "", err
 	}
 
-	return removeEmptyLines(out.String()) + "\n"
+	return removeEmptyLines(out.String()) + "\n", nil
+}
+
+// Returns the check command with every occurrence of the placeholder replaced by the path of the candidate.
+func checkCommand(c *Config, candidate string) string {
+	return strings.Replace(c.CheckCommand, candidatePlaceholder, candidate, -1)
+}
+
+// Reports whether the check command validates the candidate instead of the current configuration file.
+func checksCandidate(c *Config) bool {
+	return strings.Contains(c.CheckCommand, candidatePlaceholder) || strings.Contains(c.CheckCommand, candidateEnv)
+}
+
+// Executes command with the additional environment variables env and returns what it has written to stderr.
+func runCommand(command string, env ...string) (string, error) {
+	cmd := exec.Command("/bin/bash", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+
+	var cmdErr bytes.Buffer
+	cmd.Stderr = &cmdErr
+
+	err := cmd.Run()
+
+	return cmdErr.String(), err
+}
+
+// Writes content to a new file in the directory of path and returns the path of the new file. The file gets the
+// permissions of path or 0644 if path does not exist. Renaming it to path replaces path atomically.
+func writeCandidate(path, content string) (string, error) {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".candidate")
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Unable to create candidate of config file '%s': %s", path, err))
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.New(fmt.Sprintf("Unable to write candidate of config file '%s': %s", path, err))
+	}
+
+	return f.Name(), nil
 }
 
 func readExistingFile(fp string) (string, error) {
@@ -142,19 +465,85 @@ func removeEmptyLines(in string) string {
 
 // Create a new HAProxyGenerator, reading configuration from environment variables.
 func NewGenerator(c *Config) *HAProxyGenerator {
+	commandFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "proxy",
+		Name:      "command_failures_count",
+		Help:      "Number of failed executions of the check command and the reload command",
+	}, []string{"command"})
+	commandFailures = prometheus.MustRegisterOrGet(commandFailures).(*prometheus.CounterVec)
+
+	heldReloads := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "proxy",
+		Name:      "held_reloads_count",
+		Help:      "Number of reloads held back because of the minimum interval or the maximum of reloads per hour",
+	}, []string{"limit"})
+	heldReloads = prometheus.MustRegisterOrGet(heldReloads).(*prometheus.CounterVec)
+
 	return &HAProxyGenerator{
-		c: c,
+		c:               c,
+		commandFailures: commandFailures,
+		heldReloads:     heldReloads,
+		wakeup:          make(chan string, 1),
 	}
 }
 
-func init() {
-	var c Config
+func validate(mc module.Config) error {
+	c := mc.(*Config)
 
-	envconfig.Process("proxym_proxy", &c)
+	if c.ConfigFilePath == "" {
+		return errors.New("PROXYM_PROXY_CONFIG_FILE_PATH not set")
+	}
+
+	if c.ReloadCommand == "" {
+		return errors.New("PROXYM_PROXY_RELOAD_COMMAND not set")
+	}
 
-	if c.Enabled {
-		cg := NewGenerator(&c)
+	if c.TemplatePath == "" {
+		return errors.New("PROXYM_PROXY_TEMPLATE_PATH not set")
+	}
 
-		manager.AddConfigGenerator(cg)
+	if c.CheckCommand != "" && !checksCandidate(c) {
+		return fmt.Errorf("PROXYM_PROXY_CHECK_COMMAND contains neither %s nor $%s and would check the current "+
+			"configuration file instead of the new one", candidatePlaceholder, candidateEnv)
 	}
+
+	return nil
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
+
+	g := NewGenerator(c)
+	g.event = m.CurrentRefreshEvent
+	g.reconfigure = m.Reconfigure
+
+	if c.HistoryDir != "" {
+		hi, err := newHistory(c.HistoryDir)
+		if err != nil {
+			return err
+		}
+
+		g.history = hi
+
+		m.RegisterHttpHandleFunc("GET", "/proxy/revisions", g.listRevisionsHandler)
+		m.RegisterHttpHandleFunc("GET", "/proxy/revisions/:id", g.getRevisionHandler)
+		m.RegisterHttpHandleFunc("POST", "/proxy/revisions/:id/pin", g.pinHandler)
+		m.RegisterHttpHandleFunc("DELETE", "/proxy/pin", g.unpinHandler)
+	}
+
+	m.AddConfigGenerator(g)
+	m.AddNotifier(g)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "proxy",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
 }
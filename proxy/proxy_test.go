@@ -1,10 +1,17 @@
 package haproxy
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/require"
 	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestHAProxyGeneratorTcpConfig(t *testing.T) {
@@ -183,3 +190,332 @@ bind *:80
 
 	require.Equal(t, expectedConfig, haproxConfig)
 }
+
+func TestHAProxyGeneratorDryRun(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	f, err := ioutil.TempFile("", "proxym")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	current := "global\nlog /dev/log  local0\n"
+	f.WriteString(current)
+	f.Close()
+
+	haproxy := HAProxyGenerator{
+		c: &Config{
+			ConfigFilePath: f.Name(),
+			ReloadCommand:  "exit 1",
+			TemplatePath:   settingsPath + "/global.cfg",
+		},
+	}
+
+	diff, err := haproxy.DryRun([]*types.Service{}, &types.ChangeSet{})
+
+	require.Nil(t, err)
+	require.Contains(t, diff, "--- "+f.Name())
+	require.Contains(t, diff, "+log /dev/log  local1 notice\n")
+	require.Contains(t, diff, "+bind *:80\n")
+
+	content, _ := ioutil.ReadFile(f.Name())
+	require.Equal(t, current, string(content))
+}
+
+func TestHAProxyGeneratorHoldsReloadWithinMinInterval(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	haproxy := NewGenerator(&Config{
+		ConfigFilePath:    dir + "/haproxy.cfg",
+		ReloadCommand:     "echo reload >> " + dir + "/reloads",
+		ReloadMinInterval: 60,
+		TemplatePath:      settingsPath + "/global.cfg",
+	})
+
+	require.Nil(t, haproxy.Generate([]*types.Service{}))
+
+	service := &types.Service{
+		Id:                "redis",
+		TransportProtocol: "tcp",
+		ServicePort:       41000,
+		Hosts:             []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}},
+	}
+
+	require.Nil(t, haproxy.Generate([]*types.Service{service}))
+
+	reloads, _ := ioutil.ReadFile(dir + "/reloads")
+	require.Equal(t, "reload\n", string(reloads))
+
+	content, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.NotContains(t, string(content), "redis")
+	require.True(t, haproxy.scheduled.After(time.Now()))
+
+	haproxy.lastReload = time.Now().Add(-61 * time.Second)
+
+	require.Nil(t, haproxy.Generate([]*types.Service{service}))
+
+	reloads, _ = ioutil.ReadFile(dir + "/reloads")
+	require.Equal(t, "reload\nreload\n", string(reloads))
+}
+
+func TestHAProxyGeneratorReloadDelayMaxPerHour(t *testing.T) {
+	now := time.Now()
+
+	haproxy := HAProxyGenerator{
+		c:       &Config{ReloadMaxPerHour: 2},
+		reloads: []time.Time{now.Add(-2 * time.Hour), now.Add(-50 * time.Minute), now.Add(-10 * time.Minute)},
+	}
+
+	delay, reason := haproxy.reloadDelay(now)
+
+	require.Equal(t, 10*time.Minute, delay)
+	require.Equal(t, "max_per_hour", reason)
+	require.Len(t, haproxy.reloads, 2)
+
+	haproxy.c.ReloadMaxPerHour = 3
+
+	delay, _ = haproxy.reloadDelay(now)
+
+	require.Equal(t, time.Duration(0), delay)
+}
+
+func TestHAProxyGeneratorStartTriggersRefreshWhenHeldReloadIsDue(t *testing.T) {
+	haproxy := NewGenerator(&Config{})
+	refresh := make(chan types.RefreshEvent, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go haproxy.Start(ctx, refresh)
+
+	haproxy.hold(time.Now(), 10*time.Millisecond, "min_interval")
+
+	select {
+	case event := <-refresh:
+		require.Equal(t, "proxy", event.Notifier)
+	case <-time.After(time.Second):
+		t.Fatal("No refresh triggered")
+	}
+}
+
+func TestHAProxyGeneratorRollsBackToRevision(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	haproxy := NewGenerator(&Config{
+		ConfigFilePath: dir + "/haproxy.cfg",
+		HistorySize:    10,
+		ReloadCommand:  "echo reload >> " + dir + "/reloads",
+		TemplatePath:   settingsPath + "/global.cfg",
+	})
+	haproxy.event = func() types.RefreshEvent { return types.NewRefreshEvent("marathon", "deployment_success") }
+	haproxy.history, err = newHistory(dir + "/history")
+	require.Nil(t, err)
+
+	service := &types.Service{
+		Id:                "redis",
+		TransportProtocol: "tcp",
+		ServicePort:       41000,
+		Hosts:             []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}},
+	}
+
+	require.Nil(t, haproxy.Generate([]*types.Service{}))
+	require.Nil(t, haproxy.Generate([]*types.Service{service}))
+
+	req, _ := http.NewRequest("GET", "/proxy/revisions/2?:id=2", nil)
+	w := httptest.NewRecorder()
+
+	haproxy.getRevisionHandler(w, req)
+
+	var revision Revision
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &revision))
+	require.Equal(t, "deployment_success", revision.Event.Reason)
+	require.Contains(t, revision.Diff, "+listen redis :41000")
+
+	req, _ = http.NewRequest("POST", "/proxy/revisions/1/pin?:id=1", nil)
+	w = httptest.NewRecorder()
+
+	haproxy.pinHandler(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	content, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.NotContains(t, string(content), "redis")
+
+	// Automatic updates are suspended.
+	require.Nil(t, haproxy.Generate([]*types.Service{service}))
+
+	content, _ = ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.NotContains(t, string(content), "redis")
+
+	req, _ = http.NewRequest("GET", "/proxy/revisions", nil)
+	w = httptest.NewRecorder()
+
+	haproxy.listRevisionsHandler(w, req)
+
+	var revisions []*Revision
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 3)
+	require.Equal(t, "rollback to revision 1", revisions[0].Event.Reason)
+	require.True(t, revisions[0].Pinned)
+
+	req, _ = http.NewRequest("DELETE", "/proxy/pin", nil)
+	w = httptest.NewRecorder()
+
+	haproxy.unpinHandler(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "configuration unpinned", <-haproxy.wakeup)
+
+	require.Nil(t, haproxy.Generate([]*types.Service{service}))
+
+	content, _ = ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.Contains(t, string(content), "redis")
+
+	reloads, _ := ioutil.ReadFile(dir + "/reloads")
+	require.Equal(t, "reload\nreload\nreload\nreload\n", string(reloads))
+}
+
+func TestHAProxyGeneratorPinUnknownRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	haproxy := NewGenerator(&Config{ConfigFilePath: dir + "/haproxy.cfg"})
+	haproxy.history, err = newHistory(dir)
+	require.Nil(t, err)
+
+	req, _ := http.NewRequest("POST", "/proxy/revisions/5/pin?:id=5", nil)
+	w := httptest.NewRecorder()
+
+	haproxy.pinHandler(w, req)
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, 0, haproxy.history.pinnedRevision())
+}
+
+func TestHAProxyGeneratorKeepsConfigFileIfCheckFails(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	current := "global\nlog /dev/log  local0\n"
+	ioutil.WriteFile(dir+"/haproxy.cfg", []byte(current), 0640)
+
+	haproxy := NewGenerator(&Config{
+		CheckCommand:   `cp "$PROXYM_PROXY_CANDIDATE_FILE" ` + dir + "/checked; exit 1",
+		ConfigFilePath: dir + "/haproxy.cfg",
+		ReloadCommand:  "echo reload >> " + dir + "/reloads",
+		TemplatePath:   settingsPath + "/global.cfg",
+	})
+
+	require.NotNil(t, haproxy.Generate([]*types.Service{}))
+
+	content, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.Equal(t, current, string(content))
+
+	checked, _ := ioutil.ReadFile(dir + "/checked")
+	require.Contains(t, string(checked), "bind *:80")
+
+	files, _ := ioutil.ReadDir(dir)
+	require.Len(t, files, 2)
+
+	_, err = os.Stat(dir + "/reloads")
+	require.True(t, os.IsNotExist(err))
+
+	haproxy.c.CheckCommand = `test -s "$PROXYM_PROXY_CANDIDATE_FILE"`
+
+	require.Nil(t, haproxy.Generate([]*types.Service{}))
+
+	content, _ = ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.Contains(t, string(content), "bind *:80")
+
+	fi, _ := os.Stat(dir + "/haproxy.cfg")
+	require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestHAProxyGeneratorRestoresConfigFileIfReloadFails(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	haproxy := NewGenerator(&Config{
+		ConfigFilePath: dir + "/haproxy.cfg",
+		// Fails as long as the configuration file contains the service "redis".
+		ReloadCommand: "echo reload >> " + dir + "/reloads; ! grep -q redis " + dir + "/haproxy.cfg",
+		TemplatePath:  settingsPath + "/global.cfg",
+	})
+
+	require.Nil(t, haproxy.Generate([]*types.Service{}))
+
+	previous, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+
+	service := &types.Service{
+		Id:                "redis",
+		TransportProtocol: "tcp",
+		ServicePort:       41000,
+		Hosts:             []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}},
+	}
+
+	err = haproxy.Generate([]*types.Service{service})
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "restored previous configuration")
+
+	content, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.Equal(t, string(previous), string(content))
+
+	reloads, _ := ioutil.ReadFile(dir + "/reloads")
+	require.Equal(t, "reload\nreload\nreload\n", string(reloads))
+}
+
+func TestHAProxyGeneratorChecksCandidateInPlaceOfPlaceholder(t *testing.T) {
+	settingsPath, _ := filepath.Abs("../tests/fixtures/haproxy")
+
+	dir, err := ioutil.TempDir("", "proxym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	haproxy := NewGenerator(&Config{
+		// Fails if the checked file contains the service "redis".
+		CheckCommand:   "! grep -q redis {{candidate}} " + dir + "/haproxy.cfg.d/extra.cfg",
+		ConfigFilePath: dir + "/haproxy.cfg",
+		ReloadCommand:  "true",
+		TemplatePath:   settingsPath + "/global.cfg",
+	})
+
+	require.Nil(t, os.Mkdir(dir+"/haproxy.cfg.d", 0755))
+	require.Nil(t, ioutil.WriteFile(dir+"/haproxy.cfg.d/extra.cfg", []byte("defaults\n"), 0644))
+
+	require.True(t, checksCandidate(haproxy.c))
+	require.Nil(t, validate(haproxy.c))
+	// Paths that contain ConfigFilePath are kept.
+	require.Equal(t, "! grep -q redis /tmp/candidate "+dir+"/haproxy.cfg.d/extra.cfg", checkCommand(haproxy.c, "/tmp/candidate"))
+	require.Nil(t, haproxy.Generate([]*types.Service{}))
+
+	service := &types.Service{
+		Id:                "redis",
+		TransportProtocol: "tcp",
+		ServicePort:       41000,
+		Hosts:             []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}},
+	}
+
+	require.NotNil(t, haproxy.Generate([]*types.Service{service}))
+
+	content, _ := ioutil.ReadFile(dir + "/haproxy.cfg")
+	require.NotContains(t, string(content), "redis")
+
+	haproxy.c.CheckCommand = "haproxy -c -f " + dir + "/haproxy.cfg"
+	require.False(t, checksCandidate(haproxy.c))
+	require.NotNil(t, validate(haproxy.c))
+}
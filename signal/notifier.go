@@ -1,35 +1,47 @@
 package signal
 
 import (
-	"github.com/kelseyhightower/envconfig"
+	"context"
 	"github.com/wndhydrnt/proxym/log"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"github.com/wndhydrnt/proxym/types"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 )
 
+var logger = log.Module("signal")
+
 type Config struct {
 	Enabled bool
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
 // Waits for the signal "USR1" and triggers a refresh of configuration data.
 type Notifier struct{}
 
-func (n *Notifier) Start(refresh chan string, quit chan int, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (n *Notifier) Start(ctx context.Context, refresh chan types.RefreshEvent) {
 	c := make(chan os.Signal, 1)
 
 	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
 
 	for {
 		select {
-		case <-c:
-			log.AppLog.Info("Triggering refresh")
-			refresh <- "refresh"
-		case <-quit:
+		case s := <-c:
+			logger.Info("Triggering refresh")
+
+			select {
+			case refresh <- types.NewRefreshEvent("signal", s.String()):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -41,13 +53,13 @@ func NewNotifier() *Notifier {
 }
 
 func init() {
-	var c Config
-
-	envconfig.Process("proxym_signal", &c)
+	module.Register(&module.Module{
+		Name:      "signal",
+		NewConfig: func() module.Config { return &Config{} },
+		Setup: func(m *manager.Manager, c module.Config) error {
+			m.AddNotifier(NewNotifier())
 
-	if c.Enabled {
-		n := NewNotifier()
-
-		manager.AddNotifier(n)
-	}
+			return nil
+		},
+	})
 }
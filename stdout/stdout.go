@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/kelseyhightower/envconfig"
 	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
 	"github.com/wndhydrnt/proxym/types"
 )
 
@@ -13,6 +13,11 @@ type Config struct {
 	Enabled bool
 }
 
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
 type ConfigGenerator struct{}
 
 func (c *ConfigGenerator) Generate(services []*types.Service) error {
@@ -32,11 +37,13 @@ func (c *ConfigGenerator) Generate(services []*types.Service) error {
 }
 
 func init() {
-	var c Config
-
-	envconfig.Process("proxym_stdout", &c)
-
-	if c.Enabled {
-		manager.AddConfigGenerator(&ConfigGenerator{})
-	}
+	module.Register(&module.Module{
+		Name:      "stdout",
+		NewConfig: func() module.Config { return &Config{} },
+		Setup: func(m *manager.Manager, c module.Config) error {
+			m.AddConfigGenerator(&ConfigGenerator{})
+
+			return nil
+		},
+	})
 }
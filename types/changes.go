@@ -0,0 +1,146 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A ChangeSetConfigGenerator is a ConfigGenerator that also receives the changes since the last successful refresh.
+// The Manager calls GenerateChanges instead of Generate if a ConfigGenerator implements this interface.
+type ChangeSetConfigGenerator interface {
+	GenerateChanges(services []*Service, changes *ChangeSet) error
+}
+
+// A DryRunConfigGenerator is a ConfigGenerator that is able to describe what it would change without changing anything,
+// e.g. by returning a diff of a configuration file. The Manager calls DryRun instead of Generate or GenerateChanges
+// while it operates in dry-run mode.
+type DryRunConfigGenerator interface {
+	DryRun(services []*Service, changes *ChangeSet) (string, error)
+}
+
+// A ServiceChange describes how a Service differs between two refreshes.
+type ServiceChange struct {
+	Current      *Service
+	HostsAdded   []Host
+	HostsRemoved []Host
+	Previous     *Service
+}
+
+// A ChangeSet describes the differences between the services of two refreshes.
+type ChangeSet struct {
+	Added   []*Service
+	Changed []*ServiceChange
+	Removed []*Service
+}
+
+// Empty returns true if nothing has changed.
+func (cs *ChangeSet) Empty() bool {
+	return len(cs.Added) == 0 && len(cs.Changed) == 0 && len(cs.Removed) == 0
+}
+
+func (cs *ChangeSet) String() string {
+	added := make([]string, len(cs.Added))
+	for i, s := range cs.Added {
+		added[i] = s.Id
+	}
+
+	changed := make([]string, len(cs.Changed))
+	for i, c := range cs.Changed {
+		changed[i] = fmt.Sprintf("%s (+%d/-%d hosts)", c.Current.Id, len(c.HostsAdded), len(c.HostsRemoved))
+	}
+
+	removed := make([]string, len(cs.Removed))
+	for i, s := range cs.Removed {
+		removed[i] = s.Id
+	}
+
+	return fmt.Sprintf("added: [%s] changed: [%s] removed: [%s]", strings.Join(added, ", "), strings.Join(changed, ", "), strings.Join(removed, ", "))
+}
+
+// Diff compares two lists of services by their Id.
+func Diff(previous, current []*Service) *ChangeSet {
+	cs := &ChangeSet{}
+
+	previousById := make(map[string]*Service)
+	for _, s := range previous {
+		previousById[s.Id] = s
+	}
+
+	currentById := make(map[string]*Service)
+	for _, s := range current {
+		currentById[s.Id] = s
+	}
+
+	for _, s := range current {
+		p, ok := previousById[s.Id]
+		if !ok {
+			cs.Added = append(cs.Added, s)
+			continue
+		}
+
+		hostsAdded := diffHosts(s.Hosts, p.Hosts)
+		hostsRemoved := diffHosts(p.Hosts, s.Hosts)
+
+		if len(hostsAdded) > 0 || len(hostsRemoved) > 0 || !equalSettings(p, s) {
+			cs.Changed = append(cs.Changed, &ServiceChange{
+				Current:      s,
+				HostsAdded:   hostsAdded,
+				HostsRemoved: hostsRemoved,
+				Previous:     p,
+			})
+		}
+	}
+
+	for _, s := range previous {
+		if _, ok := currentById[s.Id]; !ok {
+			cs.Removed = append(cs.Removed, s)
+		}
+	}
+
+	return cs
+}
+
+// Returns all hosts in a that are not in b.
+func diffHosts(a, b []Host) []Host {
+	var diff []Host
+
+	known := make(map[Host]struct{})
+	for _, h := range b {
+		known[h] = struct{}{}
+	}
+
+	for _, h := range a {
+		if _, ok := known[h]; !ok {
+			diff = append(diff, h)
+		}
+	}
+
+	return diff
+}
+
+// Compares all fields of two services except Hosts.
+func equalSettings(a, b *Service) bool {
+	if a.ApplicationProtocol != b.ApplicationProtocol || a.Config != b.Config || a.Port != b.Port ||
+		a.ProxyPath != b.ProxyPath || a.ServicePort != b.ServicePort || a.Source != b.Source ||
+		a.TransportProtocol != b.TransportProtocol {
+		return false
+	}
+
+	if len(a.Domains) != len(b.Domains) {
+		return false
+	}
+
+	domainsA := append([]string(nil), a.Domains...)
+	domainsB := append([]string(nil), b.Domains...)
+	sort.Strings(domainsA)
+	sort.Strings(domainsB)
+
+	for i := range domainsA {
+		if domainsA[i] != domainsB[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	previous := []*Service{
+		&Service{Id: "unchanged", Domains: []string{"a.unit.test", "b.unit.test"}, Hosts: []Host{Host{Ip: "10.10.10.10", Port: 31001}}},
+		&Service{Id: "hosts", Hosts: []Host{Host{Ip: "10.10.10.10", Port: 31002}, Host{Ip: "10.10.10.11", Port: 31002}}},
+		&Service{Id: "config", Config: "option httpchk"},
+		&Service{Id: "removed"},
+	}
+
+	current := []*Service{
+		&Service{Id: "unchanged", Domains: []string{"b.unit.test", "a.unit.test"}, Hosts: []Host{Host{Ip: "10.10.10.10", Port: 31001}}},
+		&Service{Id: "hosts", Hosts: []Host{Host{Ip: "10.10.10.11", Port: 31002}, Host{Ip: "10.10.10.12", Port: 31002}}},
+		&Service{Id: "config", Config: "option forwardfor"},
+		&Service{Id: "added"},
+	}
+
+	cs := Diff(previous, current)
+
+	require.False(t, cs.Empty())
+
+	require.Len(t, cs.Added, 1)
+	require.Equal(t, "added", cs.Added[0].Id)
+
+	require.Len(t, cs.Removed, 1)
+	require.Equal(t, "removed", cs.Removed[0].Id)
+
+	require.Len(t, cs.Changed, 2)
+	require.Equal(t, "hosts", cs.Changed[0].Current.Id)
+	require.Equal(t, []Host{Host{Ip: "10.10.10.12", Port: 31002}}, cs.Changed[0].HostsAdded)
+	require.Equal(t, []Host{Host{Ip: "10.10.10.10", Port: 31002}}, cs.Changed[0].HostsRemoved)
+	require.Equal(t, "config", cs.Changed[1].Current.Id)
+	require.Equal(t, "option httpchk", cs.Changed[1].Previous.Config)
+	require.Len(t, cs.Changed[1].HostsAdded, 0)
+	require.Len(t, cs.Changed[1].HostsRemoved, 0)
+}
+
+func TestDiffEmpty(t *testing.T) {
+	services := []*Service{&Service{Id: "unittest", Hosts: []Host{Host{Ip: "10.10.10.10", Port: 31001}}}}
+
+	require.True(t, Diff(services, services).Empty())
+}
@@ -1,7 +1,8 @@
 package types
 
 import (
-	"sync"
+	"context"
+	"time"
 )
 
 type Annotator interface {
@@ -13,10 +14,49 @@ type ConfigGenerator interface {
 	Generate(services []*Service) error
 }
 
+// An Elector decides which of several instances of proxym is the leader.
+// ConfigGenerators that modify shared state, e.g. Hipache, are only called on the leader.
+type Elector interface {
+	IsLeader() bool
+}
+
+// A HealthChecker is a component that reports whether it is able to do its job, e.g. whether it is connected to an
+// external system. The Manager considers itself not ready while a check fails.
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+// A Filter decides which services are passed to Annotators and ConfigGenerators. It is called before all Annotators
+// and returns the services to keep.
+type Filter interface {
+	Filter(services []*Service) []*Service
+}
+
 // A Notifier recognizes changes in your system. For example, it could regularly poll an API or listen on an event bus.
 // If something changes, it notifies the Manager to trigger a refresh.
+// Start blocks until ctx is done.
 type Notifier interface {
-	Start(refresh chan string, quit chan int, wg *sync.WaitGroup)
+	Start(ctx context.Context, refresh chan RefreshEvent)
+}
+
+// A Preloader is a Notifier that keeps data up to date which a ServiceGenerator or Annotator depends on, e.g. the
+// current leader of a cluster. Preload loads the data once without starting the Notifier.
+type Preloader interface {
+	Preload() error
+}
+
+// A RefreshEvent is sent by a Notifier to trigger a refresh.
+type RefreshEvent struct {
+	// Name of the Notifier that sent the event.
+	Notifier string `json:"notifier"`
+	// What caused the event, e.g. the type of an event received from Marathon or the path of a file that changed.
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewRefreshEvent creates a RefreshEvent with the current time as its timestamp.
+func NewRefreshEvent(notifier, reason string) RefreshEvent {
+	return RefreshEvent{Notifier: notifier, Reason: reason, Timestamp: time.Now()}
 }
 
 // A ServiceGenerator reads information about nodes and creates a list of services.
@@ -24,6 +64,58 @@ type ServiceGenerator interface {
 	Generate() ([]*Service, error)
 }
 
+// A ContextServiceGenerator is a ServiceGenerator that aborts the generation of services once ctx is done.
+type ContextServiceGenerator interface {
+	GenerateContext(ctx context.Context) ([]*Service, error)
+}
+
+type serviceGeneratorAdapter struct {
+	sg ServiceGenerator
+}
+
+type generateResult struct {
+	services []*Service
+	err      error
+}
+
+func (a *serviceGeneratorAdapter) GenerateContext(ctx context.Context) ([]*Service, error) {
+	result := make(chan generateResult, 1)
+
+	go func() {
+		services, err := a.sg.Generate()
+		result <- generateResult{services: services, err: err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.services, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// AdaptServiceGenerator turns a ServiceGenerator into a ContextServiceGenerator.
+// The call to Generate() of the ServiceGenerator is not aborted when ctx is done, but its result is discarded.
+func AdaptServiceGenerator(sg ServiceGenerator) ContextServiceGenerator {
+	if csg, ok := sg.(ContextServiceGenerator); ok {
+		return csg
+	}
+
+	return &serviceGeneratorAdapter{sg: sg}
+}
+
+// A Validator checks services after all Annotators have been applied. The Manager drops every service a Validator
+// returns a Violation for before it passes the services to ConfigGenerators.
+type Validator interface {
+	Validate(services []*Service) []Violation
+}
+
+// A Violation describes why a Validator rejected a Service.
+type Violation struct {
+	Reason  string
+	Service *Service
+}
+
 // A host is an IP and a port where traffic should be proxied to.
 type Host struct {
 	Ip   string
@@ -50,3 +142,13 @@ func (s *Service) ListenPort() int {
 	}
 	return s.ServicePort
 }
+
+// Copy returns a deep copy of the Service.
+func (s *Service) Copy() *Service {
+	c := *s
+
+	c.Domains = append([]string(nil), s.Domains...)
+	c.Hosts = append([]Host(nil), s.Hosts...)
+
+	return &c
+}
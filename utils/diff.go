@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Number of unchanged lines shown before and after a change.
+const diffContext = 3
+
+// Limits the time spent on texts that have little in common. A range that requires more changes is shown as removed
+// and added completely.
+const diffMaxEdits = 1000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns the differences between two texts in the unified diff format.
+// Returns an empty string if both texts are equal.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers in both texts before each operation.
+	fromLine := make([]int, len(ops)+1)
+	toLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		fromLine[i+1] = fromLine[i]
+		toLine[i+1] = toLine[i]
+
+		if op.kind != '+' {
+			fromLine[i+1]++
+		}
+
+		if op.kind != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk as long as the next change is close enough to share context.
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+
+			end = next
+		}
+
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(fromLine[start], fromLine[stop]-fromLine[start]),
+			hunkRange(toLine[start], toLine[stop]-toLine[start]))
+
+		for _, op := range ops[start:stop] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+
+		i = stop
+	}
+
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Computes the shortest edit script that turns a into b using the linear space variant of the algorithm of
+// Eugene W. Myers. Memory grows with the number of lines, not with the square of the number of changes.
+func diffLines(a, b []string) []diffOp {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+
+	return d.ops
+}
+
+type differ struct {
+	a   []string
+	b   []string
+	ops []diffOp
+}
+
+func (d *differ) emit(kind byte, lines []string) {
+	for _, line := range lines {
+		d.ops = append(d.ops, diffOp{kind: kind, line: line})
+	}
+}
+
+// Appends the operations that turn a[a0:a1] into b[b0:b1].
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	prefix := a0
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+	d.emit(' ', d.a[prefix:a0])
+
+	suffix := a1
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+
+	switch {
+	case a0 == a1:
+		d.emit('+', d.b[b0:b1])
+	case b0 == b1:
+		d.emit('-', d.a[a0:a1])
+	default:
+		x, y, ok := d.middleSnake(a0, a1, b0, b1)
+		if ok {
+			d.compare(a0, x, b0, y)
+			d.compare(x, a1, y, b1)
+		} else {
+			d.emit('-', d.a[a0:a1])
+			d.emit('+', d.b[b0:b1])
+		}
+	}
+
+	d.emit(' ', d.a[a1:suffix])
+}
+
+// Searches the shortest edit script of a[a0:a1] and b[b0:b1] from both ends at once and returns the point at which both
+// searches meet. The ranges must neither be empty nor start or end with the same line. Returns false if the ranges
+// have no line in common or more than diffMaxEdits changes are required.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (int, int, bool) {
+	n, m := a1-a0, b1-b0
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	size := 2*maxD + 2
+
+	// Furthest x reached on each diagonal k by the forward search (vf) and the reverse search (vr). The reverse search
+	// counts x from the end of a.
+	vf := make([]int, size)
+	vr := make([]int, size)
+	for i := range vf {
+		vf[i] = -1
+		vr[i] = -1
+	}
+	vf[offset+1] = 0
+	vr[offset+1] = 0
+
+	delta := n - m
+	// The searches meet in the forward search if delta is odd and in the reverse search otherwise.
+	front := delta%2 != 0
+
+	// Diagonals that left the edit graph are skipped.
+	kfStart, kfEnd, krStart, krEnd := 0, 0, 0, 0
+
+	for e := 0; e < maxD && e < diffMaxEdits; e++ {
+		for k := -e + kfStart; k <= e-kfEnd; k += 2 {
+			var x int
+			if k == -e || (k != e && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+
+			vf[offset+k] = x
+
+			switch {
+			case x > n:
+				kfEnd += 2
+			case y > m:
+				kfStart += 2
+			case front:
+				kr := offset + delta - k
+				if kr >= 0 && kr < size && vr[kr] != -1 && x >= n-vr[kr] {
+					return a0 + x, b0 + y, true
+				}
+			}
+		}
+
+		for k := -e + krStart; k <= e-krEnd; k += 2 {
+			var x int
+			if k == -e || (k != e && vr[offset+k-1] < vr[offset+k+1]) {
+				x = vr[offset+k+1]
+			} else {
+				x = vr[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && d.a[a1-x-1] == d.b[b1-y-1] {
+				x++
+				y++
+			}
+
+			vr[offset+k] = x
+
+			switch {
+			case x > n:
+				krEnd += 2
+			case y > m:
+				krStart += 2
+			case !front:
+				kf := offset + delta - k
+				if kf >= 0 && kf < size && vf[kf] != -1 && vf[kf] >= n-x {
+					return a0 + vf[kf], b0 + vf[kf] - (kf - offset), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
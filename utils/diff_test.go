@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`
+
+	require.Equal(t, expected, UnifiedDiff("old", "new", from, to))
+}
+
+func TestUnifiedDiffEmptyFrom(t *testing.T) {
+	expected := "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n"
+
+	require.Equal(t, expected, UnifiedDiff("old", "new", "", "a\nb\n"))
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	require.Equal(t, "", UnifiedDiff("old", "new", "a\n", "a\n"))
+}
+
+func TestUnifiedDiffLargeChange(t *testing.T) {
+	var from, to []string
+	for i := 0; i < 20000; i++ {
+		from = append(from, fmt.Sprintf("server old%d 10.0.0.1:%d", i, i))
+		to = append(to, fmt.Sprintf("server new%d 10.0.0.2:%d", i, i))
+	}
+
+	diff := UnifiedDiff("old", "new", strings.Join(from, "\n"), strings.Join(to, "\n"))
+
+	require.Equal(t, 2+1+40000, strings.Count(diff, "\n"))
+	require.Contains(t, diff, "@@ -1,20000 +1,20000 @@\n")
+}
@@ -0,0 +1,152 @@
+// Package validator rejects services that would break the configuration of a proxy, e.g. two services that listen on
+// the same port or a service without any hosts.
+package validator
+
+import (
+	"fmt"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"github.com/wndhydrnt/proxym/types"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_./-]+$`)
+
+type Config struct {
+	// Comma-separated list of allowed application protocols. An empty list allows every protocol.
+	ApplicationProtocols string `envconfig:"application_protocols" default:"http,tcp" reload:"true"`
+	Enabled              bool
+	// Comma-separated list of allowed transport protocols. An empty list allows every protocol.
+	TransportProtocols string `envconfig:"transport_protocols" default:"tcp,udp" reload:"true"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
+// Validator implements the Validator interface.
+type Validator struct {
+	config *Config
+}
+
+// Validate rejects services with an invalid ID, without hosts or with an unknown protocol.
+// If several services listen on the same port or route the same domain and path, the service with the lowest ID is
+// kept and all others are rejected.
+func (v *Validator) Validate(services []*types.Service) []types.Violation {
+	var violations []types.Violation
+	var valid []*types.Service
+
+	for _, s := range services {
+		reason := v.check(s)
+		if reason != "" {
+			violations = append(violations, types.Violation{Reason: reason, Service: s})
+			continue
+		}
+
+		valid = append(valid, s)
+	}
+
+	sort.Sort(byId(valid))
+
+	ports := make(map[string]*types.Service)
+	routes := make(map[string]*types.Service)
+
+	for _, s := range valid {
+		if s.ApplicationProtocol == "http" {
+			if reason := duplicateRoute(s, routes); reason != "" {
+				violations = append(violations, types.Violation{Reason: reason, Service: s})
+				continue
+			}
+
+			for _, d := range s.Domains {
+				routes[d+s.ProxyPath] = s
+			}
+
+			continue
+		}
+
+		port := fmt.Sprintf("%d/%s", s.ListenPort(), s.TransportProtocol)
+		if first, ok := ports[port]; ok {
+			violations = append(violations, types.Violation{
+				Reason:  fmt.Sprintf("Port %s is already used by service %s", port, first.Id),
+				Service: s,
+			})
+			continue
+		}
+
+		ports[port] = s
+	}
+
+	return violations
+}
+
+// Returns why a service is invalid on its own or an empty string if it is valid.
+func (v *Validator) check(s *types.Service) string {
+	if !idPattern.MatchString(s.Id) {
+		return fmt.Sprintf("Invalid ID '%s'", s.Id)
+	}
+
+	if len(s.Hosts) == 0 {
+		return "No hosts"
+	}
+
+	if !allowed(s.ApplicationProtocol, v.config.ApplicationProtocols) {
+		return fmt.Sprintf("Unknown application protocol '%s'", s.ApplicationProtocol)
+	}
+
+	if !allowed(s.TransportProtocol, v.config.TransportProtocols) {
+		return fmt.Sprintf("Unknown transport protocol '%s'", s.TransportProtocol)
+	}
+
+	return ""
+}
+
+// Returns why one of the routes of a service conflicts with the routes of another service or an empty string if there
+// is no conflict.
+func duplicateRoute(s *types.Service, routes map[string]*types.Service) string {
+	for _, d := range s.Domains {
+		route := d + s.ProxyPath
+		if first, ok := routes[route]; ok {
+			return fmt.Sprintf("Route '%s' is already used by service %s", route, first.Id)
+		}
+	}
+
+	return ""
+}
+
+// Reports whether protocol is contained in the comma-separated list protocols. An empty protocol or an empty list are
+// always allowed.
+func allowed(protocol, protocols string) bool {
+	if protocol == "" || protocols == "" {
+		return true
+	}
+
+	for _, p := range strings.Split(protocols, ",") {
+		if strings.TrimSpace(p) == protocol {
+			return true
+		}
+	}
+
+	return false
+}
+
+type byId []*types.Service
+
+func (b byId) Len() int           { return len(b) }
+func (b byId) Less(i, j int) bool { return b[i].Id < b[j].Id }
+func (b byId) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "validator",
+		NewConfig: func() module.Config { return &Config{} },
+		Setup: func(m *manager.Manager, c module.Config) error {
+			m.AddValidator(&Validator{config: c.(*Config)})
+
+			return nil
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package validator
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"testing"
+)
+
+var hosts = []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}
+
+func newValidator() *Validator {
+	return &Validator{config: &Config{ApplicationProtocols: "http,tcp", TransportProtocols: "tcp,udp"}}
+}
+
+func TestValidateRejectsInvalidServices(t *testing.T) {
+	services := []*types.Service{
+		&types.Service{Id: "valid", Hosts: hosts, TransportProtocol: "tcp", ServicePort: 8080},
+		&types.Service{Id: "/redis", Hosts: hosts, TransportProtocol: "tcp", ServicePort: 8085},
+		&types.Service{Id: "invalid id", Hosts: hosts, TransportProtocol: "tcp", ServicePort: 8081},
+		&types.Service{Id: "no_hosts", TransportProtocol: "tcp", ServicePort: 8082},
+		&types.Service{Id: "unknown_application", ApplicationProtocol: "ftp", Hosts: hosts, ServicePort: 8083},
+		&types.Service{Id: "unknown_transport", Hosts: hosts, TransportProtocol: "sctp", ServicePort: 8084},
+	}
+
+	violations := newValidator().Validate(services)
+
+	require.Len(t, violations, 4)
+	require.Equal(t, "invalid id", violations[0].Service.Id)
+	require.Equal(t, "Invalid ID 'invalid id'", violations[0].Reason)
+	require.Equal(t, "no_hosts", violations[1].Service.Id)
+	require.Equal(t, "No hosts", violations[1].Reason)
+	require.Equal(t, "unknown_application", violations[2].Service.Id)
+	require.Equal(t, "Unknown application protocol 'ftp'", violations[2].Reason)
+	require.Equal(t, "unknown_transport", violations[3].Service.Id)
+	require.Equal(t, "Unknown transport protocol 'sctp'", violations[3].Reason)
+}
+
+func TestValidateAllowsEveryProtocolIfListIsEmpty(t *testing.T) {
+	v := &Validator{config: &Config{}}
+
+	services := []*types.Service{
+		&types.Service{Id: "sctp", ApplicationProtocol: "ftp", Hosts: hosts, TransportProtocol: "sctp"},
+	}
+
+	require.Empty(t, v.Validate(services))
+}
+
+func TestValidateRejectsPortConflicts(t *testing.T) {
+	services := []*types.Service{
+		&types.Service{Id: "b", Hosts: hosts, TransportProtocol: "tcp", ServicePort: 8080},
+		&types.Service{Id: "a", Hosts: hosts, TransportProtocol: "tcp", ServicePort: 8080},
+		&types.Service{Id: "c", Hosts: hosts, TransportProtocol: "udp", ServicePort: 8080},
+	}
+
+	violations := newValidator().Validate(services)
+
+	require.Len(t, violations, 1)
+	require.Equal(t, "b", violations[0].Service.Id)
+	require.Equal(t, "Port 8080/tcp is already used by service a", violations[0].Reason)
+}
+
+func TestValidateRejectsDuplicateRoutes(t *testing.T) {
+	services := []*types.Service{
+		&types.Service{Id: "a", ApplicationProtocol: "http", Domains: []string{"a.example.com"}, Hosts: hosts, ServicePort: 80},
+		&types.Service{Id: "b", ApplicationProtocol: "http", Domains: []string{"b.example.com", "a.example.com"}, Hosts: hosts, ServicePort: 80},
+		&types.Service{Id: "c", ApplicationProtocol: "http", Domains: []string{"b.example.com"}, Hosts: hosts, ServicePort: 80},
+		&types.Service{Id: "d", ApplicationProtocol: "http", Domains: []string{"a.example.com"}, Hosts: hosts, ProxyPath: "/api", ServicePort: 80},
+	}
+
+	violations := newValidator().Validate(services)
+
+	require.Len(t, violations, 1)
+	require.Equal(t, "b", violations[0].Service.Id)
+	require.Equal(t, "Route 'a.example.com' is already used by service a", violations[0].Reason)
+}
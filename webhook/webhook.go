@@ -0,0 +1,348 @@
+// Package webhook notifies external systems about changes of services by sending a HTTP POST request.
+//
+// Payloads are delivered in the background, so a slow or failing receiver never delays a refresh.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wndhydrnt/proxym/log"
+	"github.com/wndhydrnt/proxym/manager"
+	"github.com/wndhydrnt/proxym/module"
+	"github.com/wndhydrnt/proxym/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var logger = log.Module("webhook")
+
+// Name of the header that contains the HMAC-SHA256 signature of the payload.
+const SignatureHeader = "X-Proxym-Signature"
+
+// Number of payloads waiting for delivery. Further payloads are dropped.
+const queueSize = 100
+
+// Time to deliver payloads that are still queued on shutdown. Payloads left afterwards are dropped.
+const drainTimeout = 5 * time.Second
+
+type Config struct {
+	Enabled bool
+	// Only send webhooks while this instance is the leader.
+	LeaderOnly bool `envconfig:"leader_only"`
+	// Number of retries after a failed delivery.
+	Retries int `default:"3" reload:"true"`
+	// Time (in seconds) to wait before the first retry. Doubles with every retry.
+	RetryBackoff int `envconfig:"retry_backoff" default:"1" reload:"true"`
+	// Key used to sign payloads. Payloads are not signed if empty.
+	Secret string `reload:"true"`
+	// Time (in seconds) a single request is allowed to take.
+	Timeout int `default:"5" reload:"true"`
+	// URLs separated by commas.
+	Urls string `reload:"true"`
+}
+
+// IsEnabled implements the module.Config interface.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled
+}
+
+// A ServiceChange describes a service that has changed, including the hosts that have been added or removed.
+type ServiceChange struct {
+	HostsAdded   []types.Host   `json:"hostsAdded"`
+	HostsRemoved []types.Host   `json:"hostsRemoved"`
+	Previous     *types.Service `json:"previous"`
+	Service      *types.Service `json:"service"`
+}
+
+// Payload is the JSON document sent to every URL.
+type Payload struct {
+	Added     []*types.Service `json:"added"`
+	Changed   []*ServiceChange `json:"changed"`
+	Removed   []*types.Service `json:"removed"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+func newPayload(changes *types.ChangeSet) *Payload {
+	p := &Payload{
+		Added:     []*types.Service{},
+		Changed:   []*ServiceChange{},
+		Removed:   []*types.Service{},
+		Timestamp: time.Now(),
+	}
+
+	for _, s := range changes.Added {
+		p.Added = append(p.Added, s.Copy())
+	}
+
+	for _, c := range changes.Changed {
+		p.Changed = append(p.Changed, &ServiceChange{
+			HostsAdded:   append([]types.Host{}, c.HostsAdded...),
+			HostsRemoved: append([]types.Host{}, c.HostsRemoved...),
+			Previous:     c.Previous.Copy(),
+			Service:      c.Current.Copy(),
+		})
+	}
+
+	for _, s := range changes.Removed {
+		p.Removed = append(p.Removed, s.Copy())
+	}
+
+	return p
+}
+
+// A payload waiting for delivery. The settings are copied when the payload is queued because they can be reloaded while
+// the payload is delivered.
+type job struct {
+	c       Config
+	payload *Payload
+}
+
+// Webhook implements the ChangeSetConfigGenerator and Notifier interfaces. GenerateChanges queues a payload and Start
+// delivers queued payloads until it is stopped.
+type Webhook struct {
+	c          *Config
+	deliveries *prometheus.CounterVec
+	httpClient *http.Client
+	queue      chan *job
+}
+
+// Generate implements the ConfigGenerator interface. The Manager only calls it instead of GenerateChanges if the
+// changes are unknown, e.g. right after a start without a state file or after this instance became the leader. No
+// webhook is sent in that case.
+func (w *Webhook) Generate(services []*types.Service) error {
+	return nil
+}
+
+// GenerateChanges implements the ChangeSetConfigGenerator interface. It queues a payload if anything has changed.
+func (w *Webhook) GenerateChanges(services []*types.Service, changes *types.ChangeSet) error {
+	if changes.Empty() {
+		return nil
+	}
+
+	select {
+	case w.queue <- &job{c: *w.c, payload: newPayload(changes)}:
+	default:
+		w.deliveries.WithLabelValues("dropped").Inc()
+		logger.Error("Dropping webhook: %d payloads are waiting for delivery", queueSize)
+	}
+
+	return nil
+}
+
+// DryRun implements the DryRunConfigGenerator interface. It returns the payload that would be sent.
+func (w *Webhook) DryRun(services []*types.Service, changes *types.ChangeSet) (string, error) {
+	if changes.Empty() {
+		return "", nil
+	}
+
+	data, err := json.MarshalIndent(newPayload(changes), "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("POST %s\n%s", w.c.Urls, data), nil
+}
+
+// Start implements the Notifier interface. It delivers queued payloads until ctx is done and drains the queue
+// afterwards.
+func (w *Webhook) Start(ctx context.Context, refresh chan types.RefreshEvent) {
+	for {
+		select {
+		case j := <-w.queue:
+			w.deliver(ctx, j)
+		case <-ctx.Done():
+			w.drain()
+			return
+		}
+	}
+}
+
+// Delivers the payloads that are still queued for up to drainTimeout. Payloads left afterwards are dropped.
+func (w *Webhook) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	for {
+		if ctx.Err() != nil {
+			w.dropQueued()
+			return
+		}
+
+		select {
+		case j := <-w.queue:
+			w.deliver(ctx, j)
+		default:
+			return
+		}
+	}
+}
+
+// Empties the queue and counts every payload as dropped.
+func (w *Webhook) dropQueued() {
+	dropped := 0
+
+	for {
+		select {
+		case <-w.queue:
+			dropped++
+		default:
+			if dropped > 0 {
+				w.deliveries.WithLabelValues("dropped").Add(float64(dropped))
+				logger.Error("Dropping %d webhooks that could not be delivered before shutdown", dropped)
+			}
+
+			return
+		}
+	}
+}
+
+// Sends the payload of j to every URL.
+func (w *Webhook) deliver(ctx context.Context, j *job) {
+	data, err := json.Marshal(j.payload)
+	if err != nil {
+		logger.Error("Error marshalling webhook payload: %s", err)
+		return
+	}
+
+	for _, url := range strings.Split(j.c.Urls, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+
+		err := w.send(ctx, &j.c, url, data)
+		if err != nil {
+			w.deliveries.WithLabelValues("failure").Inc()
+			logger.Error("Unable to deliver webhook to '%s': %s", url, err)
+			continue
+		}
+
+		w.deliveries.WithLabelValues("success").Inc()
+	}
+}
+
+// Posts data to url and retries with an exponential backoff until it succeeds or all retries have been used.
+func (w *Webhook) send(ctx context.Context, c *Config, url string, data []byte) error {
+	backoff := time.Duration(c.RetryBackoff) * time.Second
+
+	var err error
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			logger.Debug("Retrying webhook to '%s' in %s: %s", url, backoff, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			backoff *= 2
+		}
+
+		err = w.post(ctx, c, url, data)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (w *Webhook) post(ctx context.Context, c *Config, url string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(c.Secret, data))
+	}
+
+	resp, err := w.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature of data as sent in SignatureHeader, e.g. "sha256=<hex encoded HMAC>".
+func Sign(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhook creates a Webhook that sends payloads to the URLs of c.
+func NewWebhook(c *Config) *Webhook {
+	deliveries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxym",
+		Subsystem: "webhook",
+		Name:      "deliveries_count",
+		Help:      "Number of webhooks delivered successfully, failed after all retries or dropped",
+	}, []string{"result"})
+	deliveries = prometheus.MustRegisterOrGet(deliveries).(*prometheus.CounterVec)
+
+	return &Webhook{
+		c:          c,
+		deliveries: deliveries,
+		httpClient: &http.Client{},
+		queue:      make(chan *job, queueSize),
+	}
+}
+
+func validate(mc module.Config) error {
+	if mc.(*Config).Urls == "" {
+		return errors.New("PROXYM_WEBHOOK_URLS not set")
+	}
+
+	return nil
+}
+
+func setup(m *manager.Manager, mc module.Config) error {
+	c := mc.(*Config)
+
+	w := NewWebhook(c)
+
+	if c.LeaderOnly {
+		m.AddLeaderConfigGenerator(w)
+	} else {
+		m.AddConfigGenerator(w)
+	}
+
+	m.AddNotifier(w)
+
+	return nil
+}
+
+func init() {
+	module.Register(&module.Module{
+		Name:      "webhook",
+		NewConfig: func() module.Config { return &Config{} },
+		Validate:  validate,
+		Setup:     setup,
+	})
+}
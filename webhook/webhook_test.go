@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"github.com/wndhydrnt/proxym/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func changes() *types.ChangeSet {
+	previous := []*types.Service{
+		&types.Service{Id: "removed"},
+		&types.Service{Id: "changed", Hosts: []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}},
+	}
+	current := []*types.Service{
+		&types.Service{Id: "changed", Hosts: []types.Host{types.Host{Ip: "10.10.10.11", Port: 31002}}},
+		&types.Service{Id: "added"},
+	}
+
+	return types.Diff(previous, current)
+}
+
+func TestWebhookDeliversSignedPayload(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	signatures := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+		signatures <- r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	w := NewWebhook(&Config{Secret: "secret", Timeout: 1, Urls: server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go w.Start(ctx, nil)
+
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(time.Second):
+		t.Fatal("Webhook not delivered")
+	}
+
+	require.Equal(t, Sign("secret", body), <-signatures)
+
+	var p Payload
+	require.Nil(t, json.Unmarshal(body, &p))
+	require.Equal(t, "added", p.Added[0].Id)
+	require.Equal(t, "changed", p.Changed[0].Service.Id)
+	require.Equal(t, []types.Host{types.Host{Ip: "10.10.10.11", Port: 31002}}, p.Changed[0].HostsAdded)
+	require.Equal(t, []types.Host{types.Host{Ip: "10.10.10.10", Port: 31001}}, p.Changed[0].HostsRemoved)
+	require.Equal(t, "removed", p.Removed[0].Id)
+}
+
+func TestWebhookRetriesFailedDelivery(t *testing.T) {
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	w := NewWebhook(&Config{Retries: 2, Timeout: 1, Urls: server.URL})
+
+	err := w.send(context.Background(), w.c, server.URL, []byte("{}"))
+
+	require.Nil(t, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = -10
+
+	err = w.send(context.Background(), w.c, server.URL, []byte("{}"))
+
+	require.NotNil(t, err)
+	require.Equal(t, -7, attempts)
+}
+
+func TestWebhookGenerateChangesDoesNotBlock(t *testing.T) {
+	w := NewWebhook(&Config{Urls: "http://127.0.0.1:1"})
+
+	for i := 0; i < queueSize+1; i++ {
+		require.Nil(t, w.GenerateChanges(nil, changes()))
+	}
+
+	require.Len(t, w.queue, queueSize)
+}
+
+func TestWebhookCopiesSettingsWhenQueueing(t *testing.T) {
+	c := &Config{Urls: "http://127.0.0.1:1"}
+	w := NewWebhook(c)
+
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+
+	c.Urls = "http://127.0.0.1:2"
+
+	j := <-w.queue
+	require.Equal(t, "http://127.0.0.1:1", j.c.Urls)
+}
+
+func TestWebhookIgnoresEmptyChanges(t *testing.T) {
+	w := NewWebhook(&Config{Urls: "http://127.0.0.1:1"})
+
+	require.Nil(t, w.GenerateChanges(nil, &types.ChangeSet{}))
+	require.Len(t, w.queue, 0)
+}
+
+func TestWebhookDrainDeliversQueuedPayloads(t *testing.T) {
+	requests := make(chan struct{}, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+	}))
+	defer server.Close()
+
+	w := NewWebhook(&Config{Timeout: 1, Urls: server.URL})
+
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+
+	w.drain()
+
+	require.Len(t, w.queue, 0)
+	require.Len(t, requests, 2)
+}
+
+func TestWebhookDropQueued(t *testing.T) {
+	w := NewWebhook(&Config{Urls: "http://127.0.0.1:1"})
+
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+	require.Nil(t, w.GenerateChanges(nil, changes()))
+
+	w.dropQueued()
+
+	require.Len(t, w.queue, 0)
+}